@@ -0,0 +1,114 @@
+// Code generated by go-conf-gen. DO NOT EDIT.
+
+package example
+
+import "github.com/lvan100/go-conf"
+
+var _ conf.Binder = (*Config)(nil)
+
+// BindFrom binds properties to Config.
+func (c *Config) BindFrom(p *conf.Properties) error {
+	return c.BindFromParam(p, conf.BindParam{Path: "Config"}, nil)
+}
+
+// BindFromParam binds properties to Config with the param and the filter.
+func (c *Config) BindFromParam(p *conf.Properties, param conf.BindParam, filter conf.Filter) error {
+	b := conf.NewStructBinder(c, p, param, filter)
+	if sub, ok := b.Embedded(".Options"); ok {
+		b.Done(sub, conf.BindEmbedded(p, &c.Options, sub, filter))
+	}
+	if sub, ok := b.Tagged(&c.Name, ".Name", conf.ParsedTag{Key: "name"}, "`value:\"${name}\"`"); ok {
+		v, err := conf.Convert[string](p, sub)
+		if err == nil {
+			c.Name = v
+		}
+		b.Done(sub, err)
+	}
+	if sub, ok := b.Tagged(&c.Ratio, ".Ratio", conf.ParsedTag{Key: "ratio", Def: "0.5", HasDef: true}, "`value:\"${ratio:=0.5}\"`"); ok {
+		v, err := conf.Convert[float32](p, sub)
+		if err == nil {
+			c.Ratio = v
+		}
+		b.Done(sub, err)
+	}
+	if sub, ok := b.Tagged(&c.Tags, ".Tags", conf.ParsedTag{Key: "tags", Def: "a,b", HasDef: true}, "`value:\"${tags:=a,b}\"`"); ok {
+		b.Done(sub, conf.BindAny(p, &c.Tags, sub, filter))
+	}
+	if sub, ok := b.Tagged(&c.Main, ".Main", conf.ParsedTag{Key: "main"}, "`value:\"${main}\"`"); ok {
+		b.Done(sub, conf.BindAny(p, &c.Main, sub, filter))
+	}
+	if sub, ok := b.Tagged(&c.Servers, ".Servers", conf.ParsedTag{Key: "servers", Def: "", HasDef: true}, "`value:\"${servers:=}\"`"); ok {
+		b.Done(sub, conf.BindAny(p, &c.Servers, sub, filter))
+	}
+	if sub, ok := b.Tagged(&c.Labels, ".Labels", conf.ParsedTag{Key: "labels", Def: "", HasDef: true}, "`value:\"${labels:=}\"`"); ok {
+		b.Done(sub, conf.BindAny(p, &c.Labels, sub, filter))
+	}
+	if sub, ok := b.Tagged(&c.Backup, ".Backup", conf.ParsedTag{Key: "backup"}, "`value:\"${backup}\"`"); ok {
+		b.Done(sub, conf.BindAny(p, &c.Backup, sub, filter))
+	}
+	if sub, ok := b.Implicit(&c.Retry, ".Retry", "Retry"); ok {
+		v, err := conf.Convert[uint8](p, sub)
+		if err == nil {
+			c.Retry = v
+		}
+		b.Done(sub, err)
+	}
+	return b.Err()
+}
+
+var _ conf.Binder = (*Server)(nil)
+
+// BindFrom binds properties to Server.
+func (c *Server) BindFrom(p *conf.Properties) error {
+	return c.BindFromParam(p, conf.BindParam{Path: "Server"}, nil)
+}
+
+// BindFromParam binds properties to Server with the param and the filter.
+func (c *Server) BindFromParam(p *conf.Properties, param conf.BindParam, filter conf.Filter) error {
+	b := conf.NewStructBinder(c, p, param, filter)
+	if sub, ok := b.Tagged(&c.Host, ".Host", conf.ParsedTag{Key: "host", Def: "localhost", HasDef: true}, "`value:\"${host:=localhost}\"`"); ok {
+		v, err := conf.Convert[string](p, sub)
+		if err == nil {
+			c.Host = v
+		}
+		b.Done(sub, err)
+	}
+	if sub, ok := b.Tagged(&c.Port, ".Port", conf.ParsedTag{Key: "port"}, "`value:\"${port}\" expr:\"$>0&&$<65536\"`"); ok {
+		v, err := conf.Convert[int](p, sub)
+		if err == nil {
+			c.Port = v
+		}
+		b.Done(sub, err)
+	}
+	if sub, ok := b.Tagged(&c.Timeout, ".Timeout", conf.ParsedTag{Key: "timeout", Def: "5s", HasDef: true}, "`value:\"${timeout:=5s}\"`"); ok {
+		b.Done(sub, conf.BindAny(p, &c.Timeout, sub, filter))
+	}
+	return b.Err()
+}
+
+var _ conf.Binder = (*Options)(nil)
+
+// BindFrom binds properties to Options.
+func (c *Options) BindFrom(p *conf.Properties) error {
+	return c.BindFromParam(p, conf.BindParam{Path: "Options"}, nil)
+}
+
+// BindFromParam binds properties to Options with the param and the filter.
+func (c *Options) BindFromParam(p *conf.Properties, param conf.BindParam, filter conf.Filter) error {
+	b := conf.NewStructBinder(c, p, param, filter)
+	if sub, ok := b.Tagged(&c.Debug, ".Debug", conf.ParsedTag{Key: "debug", Def: "false", HasDef: true}, "`value:\"${debug:=false}\"`"); ok {
+		v, err := conf.Convert[bool](p, sub)
+		if err == nil {
+			c.Debug = v
+		}
+		b.Done(sub, err)
+	}
+	if sub, ok := b.Implicit(&c.Log_Dir, ".Log_Dir", "Log_Dir"); ok {
+		v, err := conf.Convert[string](p, sub)
+		if err == nil {
+			c.Log_Dir = v
+		}
+		b.Done(sub, err)
+	}
+	return b.Err()
+}
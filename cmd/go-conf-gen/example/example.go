@@ -0,0 +1,46 @@
+// Copyright 2024 github.com/lvan100
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package example shows the code generated by go-conf-gen.
+package example
+
+import (
+	"time"
+)
+
+//go:generate go run github.com/lvan100/go-conf/cmd/go-conf-gen -type Config,Server,Options
+
+type Options struct {
+	Debug   bool   `value:"${debug:=false}"`
+	Log_Dir string // implicit key log.dir
+}
+
+type Server struct {
+	Host    string        `value:"${host:=localhost}"`
+	Port    int           `value:"${port}" expr:"$>0&&$<65536"`
+	Timeout time.Duration `value:"${timeout:=5s}"`
+}
+
+type Config struct {
+	Options
+	Name    string            `value:"${name}"`
+	Ratio   float32           `value:"${ratio:=0.5}"`
+	Tags    []string          `value:"${tags:=a,b}"`
+	Main    Server            `value:"${main}"`
+	Servers []Server          `value:"${servers:=}"`
+	Labels  map[string]string `value:"${labels:=}"`
+	Backup  *Server           `value:"${backup}"`
+	Retry   uint8
+	private int
+}
@@ -0,0 +1,315 @@
+// Copyright 2024 github.com/lvan100
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command go-conf-gen generates the methods that bind properties to structs
+// without reflection, the generated types implement conf.Binder which Bind
+// prefers. It's used with go generate:
+//
+//	//go:generate go-conf-gen -type Config,Server
+//
+// The flags are:
+//
+//	-type names   comma-separated struct names, default all the structs that
+//	              have at least one field with value tag
+//	-o file       output file, default conf_gen.go
+//
+// Fields of primitive types are parsed without reflection, other fields are
+// bound by conf.BindAny, which uses the generated methods of their types.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/lvan100/go-conf/internal/conf"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "go-conf-gen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("go-conf-gen", flag.ContinueOnError)
+	fs.SetOutput(w)
+	types := fs.String("type", "", "comma-separated struct names")
+	output := fs.String("o", "conf_gen.go", "output file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	dir := "."
+	if fs.NArg() > 0 {
+		dir = fs.Arg(0)
+	}
+
+	var names []string
+	if *types != "" {
+		names = strings.Split(*types, ",")
+	}
+
+	b, err := generate(dir, names)
+	if err != nil {
+		return err
+	}
+	file := *output
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	return os.WriteFile(file, b, 0644)
+}
+
+// primitives are the types parsed by conf.Convert without reflection.
+var primitives = []string{
+	"string", "bool", "byte", "rune",
+	"int", "int8", "int16", "int32", "int64",
+	"uint", "uint8", "uint16", "uint32", "uint64",
+	"float32", "float64",
+}
+
+// generate returns the generated source of the structs in the directory.
+func generate(dir string, names []string) ([]byte, error) {
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		pkgName string
+		structs = make(map[string]*ast.StructType)
+		order   []string
+	)
+
+	fset := token.NewFileSet()
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		if ast.IsGenerated(f) {
+			continue
+		}
+		pkgName = f.Name.Name
+		for _, decl := range f.Decls {
+			d, ok := decl.(*ast.GenDecl)
+			if !ok || d.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range d.Specs {
+				ts := spec.(*ast.TypeSpec)
+				st, ok := ts.Type.(*ast.StructType)
+				if !ok || ts.TypeParams != nil {
+					continue
+				}
+				structs[ts.Name.Name] = st
+				order = append(order, ts.Name.Name)
+			}
+		}
+	}
+
+	if names == nil {
+		for _, name := range order {
+			if hasValueTag(structs[name]) {
+				names = append(names, name)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by go-conf-gen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", pkgName)
+	buf.WriteString("import \"github.com/lvan100/go-conf\"\n")
+
+	for _, name := range names {
+		st, ok := structs[name]
+		if !ok {
+			return nil, fmt.Errorf("struct %s not found in %s", name, dir)
+		}
+		if err = genStruct(&buf, fset, name, st); err != nil {
+			return nil, err
+		}
+	}
+
+	b, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code error, %w", err)
+	}
+	return b, nil
+}
+
+// hasValueTag returns whether the struct has a field with value tag.
+func hasValueTag(st *ast.StructType) bool {
+	for _, f := range st.Fields.List {
+		if _, ok := fieldTag(f).Lookup("value"); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func fieldTag(f *ast.Field) reflect.StructTag {
+	if f.Tag == nil {
+		return ""
+	}
+	s, err := strconv.Unquote(f.Tag.Value)
+	if err != nil {
+		return ""
+	}
+	return reflect.StructTag(s)
+}
+
+// embeddedName returns the field name of an embedded type.
+func embeddedName(x ast.Expr) string {
+	switch e := x.(type) {
+	case *ast.Ident:
+		return e.Name
+	case *ast.SelectorExpr:
+		return e.Sel.Name
+	case *ast.StarExpr:
+		return embeddedName(e.X)
+	case *ast.IndexExpr:
+		return embeddedName(e.X)
+	case *ast.IndexListExpr:
+		return embeddedName(e.X)
+	default:
+		return ""
+	}
+}
+
+// primitiveName returns the name of the type when it's a primitive type.
+func primitiveName(x ast.Expr) (string, bool) {
+	if e, ok := x.(*ast.Ident); ok && slices.Contains(primitives, e.Name) {
+		return e.Name, true
+	}
+	return "", false
+}
+
+func genStruct(w *bytes.Buffer, fset *token.FileSet, name string, st *ast.StructType) error {
+
+	fmt.Fprintf(w, "\nvar _ conf.Binder = (*%s)(nil)\n", name)
+
+	fmt.Fprintf(w, "\n// BindFrom binds properties to %s.\n", name)
+	fmt.Fprintf(w, "func (c *%s) BindFrom(p *conf.Properties) error {\n", name)
+	fmt.Fprintf(w, "return c.BindFromParam(p, conf.BindParam{Path: %q}, nil)\n", name)
+	w.WriteString("}\n")
+
+	fmt.Fprintf(w, "\n// BindFromParam binds properties to %s with the param and the filter.\n", name)
+	fmt.Fprintf(w, "func (c *%s) BindFromParam(p *conf.Properties, param conf.BindParam, filter conf.Filter) error {\n", name)
+	w.WriteString("b := conf.NewStructBinder(c, p, param, filter)\n")
+
+	for _, f := range st.Fields.List {
+		tag := fieldTag(f)
+
+		names := make([]string, 0, len(f.Names))
+		for _, n := range f.Names {
+			names = append(names, n.Name)
+		}
+		embedded := len(names) == 0
+		if embedded {
+			names = append(names, embeddedName(f.Type))
+		}
+
+		for _, fieldName := range names {
+			if fieldName == "" || fieldName == "_" || !ast.IsExported(fieldName) {
+				continue
+			}
+
+			if s, ok := tag.Lookup("value"); ok {
+				var param conf.BindParam
+				if err := param.BindTag(s, tag); err != nil {
+					return fmt.Errorf("%s: %s.%s %w", fset.Position(f.Pos()), name, fieldName, err)
+				}
+				fmt.Fprintf(w, "if sub, ok := b.Tagged(&c.%s, %q, %s, %q); ok {\n",
+					fieldName, "."+fieldName, tagLiteral(param.Tag), quote(string(tag)))
+				genBind(w, f.Type, fieldName)
+				w.WriteString("}\n")
+				continue
+			}
+
+			if embedded {
+				// embed pointer type may lead to infinite recursion.
+				if _, ok := f.Type.(*ast.StarExpr); ok {
+					continue
+				}
+				fmt.Fprintf(w, "if sub, ok := b.Embedded(%q); ok {\n", "."+fieldName)
+				fmt.Fprintf(w, "b.Done(sub, conf.BindEmbedded(p, &c.%s, sub, filter))\n", fieldName)
+				w.WriteString("}\n")
+				continue
+			}
+
+			fmt.Fprintf(w, "if sub, ok := b.Implicit(&c.%s, %q, %q); ok {\n", fieldName, "."+fieldName, fieldName)
+			genBind(w, f.Type, fieldName)
+			w.WriteString("}\n")
+		}
+	}
+
+	w.WriteString("return b.Err()\n")
+	w.WriteString("}\n")
+	return nil
+}
+
+// genBind writes the code that binds a field with the param named sub.
+func genBind(w *bytes.Buffer, t ast.Expr, fieldName string) {
+	if typeName, ok := primitiveName(t); ok {
+		fmt.Fprintf(w, "v, err := conf.Convert[%s](p, sub)\n", typeName)
+		w.WriteString("if err == nil {\n")
+		fmt.Fprintf(w, "c.%s = v\n", fieldName)
+		w.WriteString("}\n")
+		w.WriteString("b.Done(sub, err)\n")
+		return
+	}
+	fmt.Fprintf(w, "b.Done(sub, conf.BindAny(p, &c.%s, sub, filter))\n", fieldName)
+}
+
+// quote returns a raw string literal of s if possible.
+func quote(s string) string {
+	if strconv.CanBackquote(s) {
+		return "`" + s + "`"
+	}
+	return strconv.Quote(s)
+}
+
+// tagLiteral returns the composite literal of a parsed tag.
+func tagLiteral(tag conf.ParsedTag) string {
+	var fields []string
+	if tag.Key != "" {
+		fields = append(fields, "Key: "+strconv.Quote(tag.Key))
+	}
+	if tag.HasDef {
+		fields = append(fields, "Def: "+strconv.Quote(tag.Def), "HasDef: true")
+	}
+	if tag.Splitter != "" {
+		fields = append(fields, "Splitter: "+strconv.Quote(tag.Splitter))
+	}
+	return "conf.ParsedTag{" + strings.Join(fields, ", ") + "}"
+}
@@ -0,0 +1,82 @@
+// Copyright 2024 github.com/lvan100
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestGenerate(t *testing.T) {
+
+	golden := filepath.Join("testdata", "fields.golden")
+	out := filepath.Join(t.TempDir(), "conf_gen.go")
+
+	var w bytes.Buffer
+	if err := run([]string{"-o", out, "testdata/fields"}, &w); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *update {
+		if err = os.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, expect) {
+		t.Fatalf("got %s, expect %s", got, expect)
+	}
+
+	testcases := []struct {
+		args []string
+		err  string
+	}{
+		{
+			args: []string{"-type", "Missing", "-o", out, "testdata/fields"},
+			err:  "struct Missing not found in testdata/fields",
+		},
+		{
+			args: []string{"-type", "Stale", "-o", out, "testdata/fields"},
+			err:  "struct Stale not found in testdata/fields",
+		},
+		{
+			args: []string{"-o", out, "testdata/missing"},
+			err:  "no such file or directory",
+		},
+		{
+			args: []string{"-unknown"},
+			err:  "flag provided but not defined: -unknown",
+		},
+	}
+
+	for _, c := range testcases {
+		err := run(c.args, &w)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("%v: got %v, expect %v", c.args, err, c.err)
+		}
+	}
+}
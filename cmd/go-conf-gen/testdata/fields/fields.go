@@ -0,0 +1,42 @@
+// Copyright 2024 github.com/lvan100
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fields is the input of the golden test of go-conf-gen.
+package fields
+
+type Base struct {
+	ID string `value:"${id}"`
+}
+
+type Pool struct {
+	_       struct{} `naming:"kebab"`
+	MaxIdle int
+}
+
+// Plain has no field with value tag, it's skipped without -type.
+type Plain struct {
+	Name string
+}
+
+type Config struct {
+	Base
+	*Pool
+	Plain `value:"${plain}"`
+
+	_, Name string `value:"${name}"`
+	a, B    int    `value:"${b:=1}"`
+	hidden  string `value:"${hidden}"`
+	Ptr     *Base  `value:"${ptr}"`
+	Count   int64
+}
@@ -0,0 +1,32 @@
+// Copyright 2024 github.com/lvan100
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+
+	"github.com/lvan100/go-conf"
+)
+
+// dump prints all the properties in the format, which is the extension of
+// a registered writer without the leading dot.
+func dump(w io.Writer, p conf.ReadOnlyProperties, format string) error {
+	b, err := p.Marshal("." + format)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
@@ -0,0 +1,101 @@
+// Copyright 2024 github.com/lvan100
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/lvan100/go-conf"
+)
+
+// explain prints the override chain of the key, and the references in its
+// value with their resolved values, the secret values are redacted.
+func explain(w io.Writer, p conf.ReadOnlyProperties, key string) error {
+	if !p.Has(key) {
+		return fmt.Errorf("property %q %w", key, conf.ErrNotExist)
+	}
+	if !hasValue(p, key) {
+		fmt.Fprintf(w, "%s is not a simple value, its sub keys are:\n", key)
+		for _, k := range p.Keys() {
+			if strings.HasPrefix(k, key+".") || strings.HasPrefix(k, key+"[") {
+				fmt.Fprintf(w, "  %s\n", k)
+			}
+		}
+		return nil
+	}
+
+	val := p.Get(key)
+	fmt.Fprintf(w, "key:      %s\n", key)
+	fmt.Fprintf(w, "value:    %q\n", val)
+	resolved, err := p.Resolve(val)
+	if err != nil {
+		fmt.Fprintf(w, "resolved: error, %v\n", err)
+	} else {
+		fmt.Fprintf(w, "resolved: %q\n", redact(p, val, resolved))
+	}
+
+	fmt.Fprintln(w, "override chain (lowest first):")
+	for i, o := range p.Origins(key) {
+		fmt.Fprintf(w, "  %d. %-40s %q\n", i+1, o.String(), o.Value)
+	}
+
+	refs, err := conf.References(val)
+	if err != nil {
+		fmt.Fprintf(w, "references: error, %v\n", err)
+		return nil
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w, "references:")
+	for _, ref := range refs {
+		s, err := p.Resolve(ref)
+		if err != nil {
+			fmt.Fprintf(w, "  %s => error, %v\n", ref, err)
+			continue
+		}
+		raw := ref
+		if tag, err := conf.ParseTag(ref); err == nil && hasValue(p, tag.Key) {
+			raw += p.Get(tag.Key)
+		}
+		fmt.Fprintf(w, "  %s => %q", ref, redact(p, raw, s))
+		if tag, err := conf.ParseTag(ref); err == nil {
+			if o, ok := p.Origin(tag.Key); ok {
+				fmt.Fprintf(w, " from %s", o.String())
+			} else if !p.Has(tag.Key) {
+				fmt.Fprint(w, " from default")
+			}
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+// redact returns Redacted when the raw value is a secret, or the resolved
+// value with the secrets redacted.
+func redact(p conf.ReadOnlyProperties, raw, resolved string) string {
+	if conf.IsSecretValue(raw) {
+		return conf.Redacted
+	}
+	return p.Redact(resolved)
+}
+
+// hasValue returns whether the key is a simple value but not a map or an array.
+func hasValue(p conf.ReadOnlyProperties, key string) bool {
+	_, ok := p.Data()[key]
+	return ok
+}
@@ -0,0 +1,170 @@
+// Copyright 2024 github.com/lvan100
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command go-conf prints the configuration merged by conf.Configuration, so
+// that the configuration can be debugged without writing Go code.
+//
+// Usage:
+//
+//	go-conf dump [flags]
+//	go-conf explain [flags] <key>
+//
+// The flags are:
+//
+//	-f file          add a file to the file layer, can be repeated
+//	-dync file       add a file to the dync layer, can be repeated
+//	-set key=value   set a property in the prop layer, can be repeated
+//	-profile names   set the active profiles spring.profiles.active
+//	-env-prefix s    prefix of environment variables, default GS_, only the
+//	                 variables with the prefix are loaded unless the variable
+//	                 INCLUDE_ENV_PATTERNS is set
+//	-D key[=value]   set a property in the args layer, can be repeated
+//	-workdir dir     working directory used to resolve relative files
+//	-o format        output format of dump: properties, yaml, json, toml
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/lvan100/go-conf"
+	"github.com/lvan100/go-conf/internal/cfgr"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "go-conf:", err)
+		os.Exit(1)
+	}
+}
+
+// stringsFlag is a flag that can be repeated.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+type options struct {
+	files     stringsFlag
+	dyncs     stringsFlag
+	props     stringsFlag
+	args      stringsFlag
+	profile   string
+	envPrefix string
+	workDir   string
+	format    string
+}
+
+func run(args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("usage: go-conf dump|explain [flags]")
+	}
+
+	var opts options
+	cmd := args[0]
+	if cmd != "dump" && cmd != "explain" {
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	fs.Var(&opts.files, "f", "add a file to the file layer")
+	fs.Var(&opts.dyncs, "dync", "add a file to the dync layer")
+	fs.Var(&opts.props, "set", "set a property in the prop layer")
+	fs.Var(&opts.args, "D", "set a property in the args layer")
+	fs.StringVar(&opts.profile, "profile", "", "set the active profiles spring.profiles.active")
+	fs.StringVar(&opts.envPrefix, "env-prefix", "GS_", "prefix of environment variables")
+	fs.StringVar(&opts.workDir, "workdir", "", "working directory")
+	fs.StringVar(&opts.format, "o", "properties", "output format: properties, yaml, json, toml")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	p, err := refresh(opts)
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "dump":
+		return dump(w, p, opts.format)
+	case "explain":
+		if fs.NArg() != 1 {
+			return errors.New("usage: go-conf explain [flags] <key>")
+		}
+		return explain(w, p, fs.Arg(0))
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}
+
+// refresh merges the configuration from the options.
+func refresh(opts options) (conf.ReadOnlyProperties, error) {
+	c := conf.NewConfiguration()
+	if opts.workDir != "" {
+		c.SetWorkDir(opts.workDir)
+	}
+	for _, s := range opts.props {
+		ss := strings.SplitN(s, "=", 2)
+		if len(ss) == 1 {
+			ss = append(ss, "true")
+		}
+		if err := c.SetProperty(ss[0], ss[1]); err != nil {
+			return nil, err
+		}
+	}
+	if opts.profile != "" {
+		if err := c.SetProperty(conf.ActiveProfilesKey, opts.profile); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range opts.files {
+		c.File().Add(s)
+	}
+	for _, s := range opts.dyncs {
+		c.Dync().Add(s)
+	}
+	c.Env().SetPrefix(opts.envPrefix)
+	c.Env().Reset(environ(opts.envPrefix))
+	var cmdArgs []string
+	for _, s := range opts.args {
+		cmdArgs = append(cmdArgs, "-D", s)
+	}
+	c.Args().Reset(cmdArgs)
+	return c.Refresh()
+}
+
+// environ returns the environment variables with the prefix when there is
+// no INCLUDE_ENV_PATTERNS, because including all the variables by default
+// usually brings invalid keys from the shell, such as `_`.
+func environ(prefix string) []string {
+	if _, ok := os.LookupEnv(cfgr.IncludeEnvPatterns); ok {
+		return os.Environ()
+	}
+	var ret []string
+	for _, s := range os.Environ() {
+		if strings.HasPrefix(s, prefix) || strings.HasPrefix(s, cfgr.ExcludeEnvPatterns+"=") {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
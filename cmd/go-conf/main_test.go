@@ -0,0 +1,106 @@
+// Copyright 2024 github.com/lvan100
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+
+	dir := t.TempDir()
+	file := dir + "/app.properties"
+	s := "name=app\nurl=http://${host:=localhost}:${port}/$${path}\ndb.host=db\n"
+	if err := os.WriteFile(file, []byte(s), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		args   []string
+		expect []string // substrings of the output
+		absent []string // substrings not in the output
+		err    string
+	}{
+		{
+			args:   []string{"dump", "-f", file, "-D", "port=80"},
+			expect: []string{"name = app", "port = 80", "db.host = db"},
+		},
+		{
+			args:   []string{"dump", "-f", file, "-o", "json"},
+			expect: []string{`"name": "app"`},
+		},
+		{
+			args: []string{"dump", "-o", "xml"},
+			err:  `unsupported file type ".xml"`,
+		},
+		{
+			args: []string{"explain", "-workdir", dir, "-f", "app.properties", "-D", "port=80", "url"},
+			expect: []string{
+				`resolved: "http://localhost:80/${path}"`,
+				"1. file:" + file,
+				"${host:=localhost} => \"localhost\" from default",
+				"${port} => \"80\" from args:-D port=80",
+			},
+			absent: []string{"${path} =>"},
+		},
+		{
+			args:   []string{"explain", "-f", file, "db"},
+			expect: []string{"db is not a simple value", "  db.host"},
+		},
+		{
+			args: []string{"explain", "-f", file, "missing"},
+			err:  `property "missing" not exist`,
+		},
+		{
+			args: []string{"explain", "-f", file},
+			err:  "usage: go-conf explain",
+		},
+		{
+			args: []string{"unknown"},
+			err:  `unknown command "unknown"`,
+		},
+		{
+			args: nil,
+			err:  "usage: go-conf dump|explain",
+		},
+	}
+
+	for _, c := range testcases {
+		var w bytes.Buffer
+		err := run(c.args, &w)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Fatalf("%v: got %v, expect %v", c.args, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: %v", c.args, err)
+		}
+		for _, s := range c.expect {
+			if !strings.Contains(w.String(), s) {
+				t.Fatalf("%v: got %q, expect %q", c.args, w.String(), s)
+			}
+		}
+		for _, s := range c.absent {
+			if strings.Contains(w.String(), s) {
+				t.Fatalf("%v: got %q, expect no %q", c.args, w.String(), s)
+			}
+		}
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/lvan100/go-conf/internal/cfgr"
 	"github.com/lvan100/go-conf/internal/conf"
 	"github.com/lvan100/go-conf/internal/expr"
+	"github.com/lvan100/go-conf/internal/secret"
 	"github.com/lvan100/go-conf/reader/json"
 	"github.com/lvan100/go-conf/reader/prop"
 	"github.com/lvan100/go-conf/reader/toml"
@@ -37,6 +38,15 @@ func init() {
 	RegisterReader(yaml.Read, ".yaml", ".yml")
 	RegisterReader(toml.Read, ".toml", ".tml")
 
+	RegisterDocumentsReader(yaml.ReadDocuments, ".yaml", ".yml")
+
+	RegisterLineReader(toml.ReadLines, ".toml", ".tml")
+
+	RegisterWriter(json.Write, ".json")
+	RegisterWriter(prop.Write, ".properties")
+	RegisterWriter(yaml.Write, ".yaml", ".yml")
+	RegisterWriter(toml.Write, ".toml", ".tml")
+
 	RegisterConverter(func(s string) (time.Time, error) {
 		return cast.ToTimeE(strings.TrimSpace(s))
 	})
@@ -44,19 +54,38 @@ func init() {
 	RegisterConverter(func(s string) (time.Duration, error) {
 		return time.ParseDuration(strings.TrimSpace(s))
 	})
+
+	RegisterFormatter(func(t time.Time) (string, error) {
+		return t.Format(time.RFC3339Nano), nil
+	})
+
+	RegisterFormatter(func(d time.Duration) (string, error) {
+		return d.String(), nil
+	})
 }
 
 /****************************** conf.Properties ******************************/
 
 var (
-	ErrNotExist      = conf.ErrNotExist
-	ErrInvalidSyntax = conf.ErrInvalidSyntax
+	ErrNotExist          = conf.ErrNotExist
+	ErrInvalidSyntax     = conf.ErrInvalidSyntax
+	ErrCircularReference = conf.ErrCircularReference
 )
 
+// SetMaxResolveDepth sets the max length of a chain of references, such as
+// `a -> b -> c`, default 64, it panics when the depth is less than 1.
+func SetMaxResolveDepth(depth int) {
+	conf.SetMaxResolveDepth(depth)
+}
+
 type (
-	Reader    = conf.Reader
-	Splitter  = conf.Splitter
-	Converter = conf.Converter
+	Reader          = conf.Reader
+	DocumentsReader = conf.DocumentsReader
+	LineReader      = conf.LineReader
+	Writer          = conf.Writer
+	Splitter        = conf.Splitter
+	Converter       = conf.Converter
+	Formatter       = conf.Formatter
 )
 
 // RegisterReader registers its Reader for some kind of file extension.
@@ -64,6 +93,23 @@ func RegisterReader(r Reader, ext ...string) {
 	conf.RegisterReader(r, ext...)
 }
 
+// RegisterDocumentsReader registers its DocumentsReader for some kind of
+// file extension, it's used to load multi-document files in Configuration.
+func RegisterDocumentsReader(r DocumentsReader, ext ...string) {
+	conf.RegisterDocumentsReader(r, ext...)
+}
+
+// RegisterLineReader registers its LineReader for some kind of file
+// extension, it's used to record the line numbers of the origins.
+func RegisterLineReader(r LineReader, ext ...string) {
+	conf.RegisterLineReader(r, ext...)
+}
+
+// RegisterWriter registers its Writer for some kind of file extension.
+func RegisterWriter(w Writer, ext ...string) {
+	conf.RegisterWriter(w, ext...)
+}
+
 // RegisterSplitter registers a Splitter and named it.
 func RegisterSplitter(name string, fn Splitter) {
 	conf.RegisterSplitter(name, fn)
@@ -75,6 +121,40 @@ func RegisterConverter(fn Converter) {
 	conf.RegisterConverter(fn)
 }
 
+// RegisterFormatter registers its formatter for non-primitive type such as
+// time.Time, time.Duration, or other user-defined value type, it's the
+// reverse of the converter and used by Properties.Unbind.
+func RegisterFormatter(fn Formatter) {
+	conf.RegisterFormatter(fn)
+}
+
+// ResolverFunc is a function called in references, such as ${env:HOME},
+// ${random.int(1,10)} and ${uuid}.
+type ResolverFunc = conf.ResolverFunc
+
+// RegisterResolverFunc registers a ResolverFunc and named it, it's called by
+// ${name:arg}, ${name(arg1,arg2)}, or ${name} when the property `name`
+// doesn't exist and the function succeeds without arguments. The built-in
+// functions are env, file, base64, upper, lower, random.int, uuid and secret.
+func RegisterResolverFunc(name string, fn ResolverFunc) {
+	conf.RegisterResolverFunc(name, fn)
+}
+
+// References returns the top level references in the string, such as ${a}
+// and ${b:=${c}} in "${a}-${b:=${c}}", the escaped "$${" is skipped.
+func References(s string) ([]string, error) {
+	return conf.References(s)
+}
+
+// NamingStrategy converts the name of a field without value tag to its key.
+type NamingStrategy = conf.NamingStrategy
+
+// RegisterNaming registers a NamingStrategy and named it. The built-in
+// strategies are lower (the default), kebab, snake, camel and exact.
+func RegisterNaming(name string, fn NamingStrategy) {
+	conf.RegisterNaming(name, fn)
+}
+
 type (
 	ValidatorInterface = conf.ValidatorInterface
 )
@@ -84,6 +164,55 @@ func SetValidator(i ValidatorInterface) {
 	conf.Validator = i
 }
 
+const (
+	// SecretPrefix is the prefix of the secret references, such as
+	// ${secret:db/password}.
+	SecretPrefix = conf.SecretPrefix
+
+	// Redacted replaces the secret values in the redacted strings.
+	Redacted = conf.Redacted
+)
+
+type (
+	Decryptor      = conf.Decryptor
+	SecretResolver = conf.SecretResolver
+)
+
+// SetDecryptor sets the Decryptor of the values in the form of ENC(...).
+func SetDecryptor(d Decryptor) {
+	conf.SetDecryptor(d)
+}
+
+// SetSecretResolver sets the SecretResolver of the ${secret:...} references.
+func SetSecretResolver(r SecretResolver) {
+	conf.SetSecretResolver(r)
+}
+
+// IsSecretValue returns whether the raw value is in the form of ENC(...) or
+// references a secret, such as ${secret:db/password}.
+func IsSecretValue(s string) bool {
+	return conf.IsSecretValue(s)
+}
+
+// AESGCM is a Decryptor by AES-GCM, it also encrypts the values.
+type AESGCM = secret.AESGCM
+
+// NewAESGCM returns an AESGCM of the 16, 24 or 32 bytes key.
+func NewAESGCM(key []byte) (*AESGCM, error) {
+	return secret.NewAESGCM(key)
+}
+
+// AESGCMFromEnv returns an AESGCM of the base64 key in the environment
+// variable.
+func AESGCMFromEnv(name string) (*AESGCM, error) {
+	return secret.FromEnv(name)
+}
+
+// AESGCMFromFile returns an AESGCM of the base64 key in the file.
+func AESGCMFromFile(file string) (*AESGCM, error) {
+	return secret.FromFile(file)
+}
+
 // Def used to set default value for conf.Get().
 func Def(v string) conf.GetOption {
 	return conf.Def(v)
@@ -99,8 +228,66 @@ func Tag(tag string) conf.BindArg {
 	return conf.Tag(tag)
 }
 
+// CollectErrors makes conf.Bind continue across all fields when errors
+// occur, and return all the errors as a *BindErrors.
+func CollectErrors() conf.BindArg {
+	return conf.CollectErrors()
+}
+
+// Defaults makes Properties.Unbind and FromStruct write the default values
+// of the tags for the zero-valued fields.
+func Defaults() conf.BindArg {
+	return conf.Defaults()
+}
+
+// Naming selects the naming of the fields without value tag for conf.Bind(),
+// such as "kebab", or "kebab,relaxed" which also matches the keys in the
+// other built-in forms. A marker field `_ struct{}` with the tag
+// `naming:"kebab"` in a struct overrides it for the struct and its fields.
+func Naming(spec string) conf.BindArg {
+	return conf.Naming(spec)
+}
+
+type (
+	BindError  = conf.BindError
+	BindErrors = conf.BindErrors
+)
+
 type BindParam = conf.BindParam
 
+// Filter is called before binding each field with value tag, the field is
+// skipped when it returns true.
+type Filter = conf.Filter
+
+// Binder is implemented by the struct types generated by go-conf-gen, Bind
+// prefers it to reflection when the target implements it.
+type Binder = conf.Binder
+
+// StructBinder binds the fields of a struct in the code generated by
+// go-conf-gen, it has the same semantics as binding by reflection.
+type StructBinder = conf.StructBinder
+
+// NewStructBinder returns a StructBinder for the struct that `i` points to.
+func NewStructBinder(i interface{}, p *Properties, param BindParam, filter Filter) StructBinder {
+	return conf.NewStructBinder(i, p, param, filter)
+}
+
+// BindAny binds properties to the value that `i` points to.
+func BindAny(p *Properties, i interface{}, param BindParam, filter Filter) error {
+	return conf.BindAny(p, i, param, filter)
+}
+
+// BindEmbedded binds properties to the embedded struct that `i` points to.
+func BindEmbedded(p *Properties, i interface{}, param BindParam, filter Filter) error {
+	return conf.BindEmbedded(p, i, param, filter)
+}
+
+// Convert returns the property value converted to T, the primitive types are
+// parsed without reflection.
+func Convert[T any](p *Properties, param BindParam) (T, error) {
+	return conf.Convert[T](p, param)
+}
+
 // Param binds properties using BindParam for conf.Bind().
 func Param(param BindParam) conf.BindArg {
 	return conf.Param(param)
@@ -117,10 +304,50 @@ func ParseTag(tag string) (ret ParsedTag, err error) {
 // Properties is a simple properties implementation.
 type Properties = conf.Properties
 
+// Origin describes where a property value comes from.
+type Origin = conf.Origin
+
+// Collision records two different spellings of a key merged into the same
+// canonical key, see Properties.EnableCanonicalKeys.
+type Collision = conf.Collision
+
 func New() *Properties {
 	return conf.New()
 }
 
+// FromStruct creates *Properties from a value, the keys are decided by the
+// same 'value' tags and naming rules used by Bind.
+func FromStruct(i interface{}, args ...conf.BindArg) (*Properties, error) {
+	return conf.FromStruct(i, args...)
+}
+
+type (
+	Change     = conf.Change
+	ChangeType = conf.ChangeType
+)
+
+const (
+	ChangeAdded    = conf.ChangeAdded
+	ChangeRemoved  = conf.ChangeRemoved
+	ChangeModified = conf.ChangeModified
+)
+
+// Diff returns the changes of keys from old to new sorted by key, a nil
+// *Properties is treated as an empty one.
+func Diff(old, new *Properties) []Change {
+	return conf.Diff(old, new)
+}
+
+// Dynamic is a value which is updated in place when the Configuration that
+// bound it refreshes, it can be read in other goroutines safely. It embeds
+// conf.Dynamic because generic type can't be aliased.
+type Dynamic[T any] struct {
+	conf.Dynamic[T]
+}
+
+// Refreshable is a value that can be bound again when properties refresh.
+type Refreshable = conf.Refreshable
+
 /**************************** cfgr.Configuration *****************************/
 
 // ReadOnlyProperties is the interface for read-only properties.
@@ -129,6 +356,72 @@ type ReadOnlyProperties = cfgr.ReadOnlyProperties
 // Configuration is a layered configuration manager.
 type Configuration = cfgr.Configuration
 
+// RefreshListener is notified after each refresh of the Configuration.
+type RefreshListener = cfgr.RefreshListener
+
+// KeyListener is notified with the changes of the subscribed keys.
+type KeyListener = cfgr.KeyListener
+
+// Source is the source of properties of a layer, *Properties is a Source.
+type Source = cfgr.Source
+
+// LayerInfo describes a layer of Configuration.
+type LayerInfo = cfgr.LayerInfo
+
+// Watcher is a Source that knows when its properties change, it's watched
+// by Configuration.Watch.
+type Watcher = cfgr.Watcher
+
+// HTTPSource is a Source that fetches properties from a URL, it polls the
+// URL with ETag when watched, and keeps the last fetched content in memory
+// and in an optional cache file for when the server is unreachable.
+type HTTPSource = cfgr.HTTPSource
+
+func NewHTTPSource(url string) *HTTPSource {
+	return cfgr.NewHTTPSource(url)
+}
+
+// KVSource is a Source that loads the keys under a prefix of a KV store, it
+// maps `app/db/host` with the prefix `app` to `db.host`, and watches the
+// keys by the HTTP APIs of the store.
+type KVSource = cfgr.KVSource
+
+// NewConsulSource returns a KVSource of the Consul agent at addr.
+func NewConsulSource(addr, prefix string) *KVSource {
+	return cfgr.NewConsulSource(addr, prefix)
+}
+
+// NewEtcdSource returns a KVSource of the etcd server at addr.
+func NewEtcdSource(addr, prefix string) *KVSource {
+	return cfgr.NewEtcdSource(addr, prefix)
+}
+
+// EnvKey maps the name of an environment variable to the property key, a
+// `_` separates the segments, a `__` is a literal `_`, and a numeric segment
+// is an index when indexes is true, such as SERVER_PORTS_1 into
+// server.ports[1], it's the default key mapper of Environment.
+func EnvKey(name string, indexes bool) string {
+	return cfgr.EnvKey(name, indexes)
+}
+
+const (
+	// ActiveProfilesKey is the property of the active profiles separated by
+	// comma, the properties of the later profiles override the earlier ones.
+	ActiveProfilesKey = cfgr.ActiveProfilesKey
+
+	// OnProfileKey gates a document of a multi-document file, the document
+	// is loaded only when one of the profiles it lists is active.
+	OnProfileKey = cfgr.OnProfileKey
+
+	// ImportKey lists the files imported by a file, the relative paths are
+	// relative to the importing file, and the imported files override the
+	// importing file.
+	ImportKey = cfgr.ImportKey
+
+	// OptionalPrefix marks an imported file that may not exist.
+	OptionalPrefix = cfgr.OptionalPrefix
+)
+
 func NewConfiguration() *Configuration {
 	return cfgr.New()
 }
@@ -15,18 +15,30 @@
 package conf_test
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"maps"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
 	"os"
 	"reflect"
+	"regexp"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/lvan100/go-conf"
+	"github.com/lvan100/go-conf/cmd/go-conf-gen/example"
 	"github.com/lvan100/go-conf/internal/flat"
 )
 
@@ -82,6 +94,138 @@ func TestParseTag(t *testing.T) {
 			t.Fatalf("got %v, expect %v", gotTag, expectTag)
 		}
 	}
+
+	{
+		testcases := map[string]conf.ParsedTag{
+			`${a:=${b:=x}}`:         {Key: "a", Def: "${b:=x}", HasDef: true},
+			`${a:={"k":"v"}}>>json`: {Key: "a", Def: `{"k":"v"}`, HasDef: true, Splitter: "json"},
+			`${a:=x>>y}`:            {Key: "a", Def: "x>>y", HasDef: true},
+			`${a:=$${b}}`:           {Key: "a", Def: "$${b}", HasDef: true},
+			`${join(${a:=1},b):=c}`: {Key: "join(${a:=1},b)", Def: "c", HasDef: true},
+			`${a:=}  >>  point `:    {Key: "a", HasDef: true, Splitter: "point"},
+			`${a:=${b:=:=}:=}`:      {Key: "a", Def: "${b:=:=}:=", HasDef: true},
+			`${a:=x}}`:              {},
+			`${a:=${b}`:             {},
+			`${a}point`:             {},
+		}
+		for tag, expect := range testcases {
+			got, err := conf.ParseTag(tag)
+			if expect == (conf.ParsedTag{}) {
+				if !errors.Is(err, conf.ErrInvalidSyntax) {
+					t.Fatalf("%s: got %v, expect %v", tag, err, conf.ErrInvalidSyntax)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", tag, err)
+			}
+			if got != expect {
+				t.Fatalf("%s: got %#v, expect %#v", tag, got, expect)
+			}
+		}
+	}
+}
+
+func FuzzParseTag(f *testing.F) {
+	for _, s := range []string{
+		"${a}", "${a:=b}>>c", "${a:=${b:=x}}", `${a:={"k":"v"}}`,
+		"${a:=$${b}}", "${a:=x}}", "${", "}", ">>", "${a:=}>>",
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, tag string) {
+		parsed, err := conf.ParseTag(tag)
+		if err != nil {
+			return
+		}
+		again, err := conf.ParseTag(parsed.String())
+		if err != nil {
+			t.Fatalf("%q: reparse %q error, %v", tag, parsed.String(), err)
+		}
+		if again != parsed {
+			t.Fatalf("%q: got %#v, expect %#v", tag, again, parsed)
+		}
+	})
+}
+
+func TestEscape(t *testing.T) {
+
+	p := conf.New()
+	_ = p.Set("name", "go-conf")
+	_ = p.Set("tpl", "hello $${user}, from ${name}")
+	_ = p.Set("ref", "${tpl}")
+	_ = p.Set("json", `${missing:={"a":{"b":"${name}"}}}`)
+	_ = p.Set("brace", "a{b")
+
+	testcases := map[string]string{
+		"$${name}":                   "${name}",
+		"$$${name}":                  "$${name}",
+		"$$$${name}":                 "$$${name}",
+		"$$":                         "$$",
+		"a}b{c":                      "a}b{c",
+		"${tpl}":                     "hello ${user}, from go-conf",
+		"${ref}":                     "hello ${user}, from go-conf",
+		"${json}":                    `{"a":{"b":"go-conf"}}`,
+		"${missing:=$${name}}":       "${name}",
+		"${missing:=${b:=x}}":        "x",
+		"${missing:=a>>b}":           "a>>b",
+		"${upper:{$${x}}}":           "{${X}}",
+		"${missing:=${b:=}}-$${end}": "-${end}",
+		"${missing:=a}b}":            "ab}",
+		"${missing:=${brace}}":       "a{b",
+	}
+	for s, expect := range testcases {
+		got, err := p.Resolve(s)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		if got != expect {
+			t.Fatalf("%s: got %q, expect %q", s, got, expect)
+		}
+	}
+
+	for _, s := range []string{"${name", "${a:=${b}", "x${", "${missing:=a{b}"} {
+		if _, err := p.Resolve(s); !errors.Is(err, conf.ErrInvalidSyntax) {
+			t.Fatalf("%s: got %v, expect %v", s, err, conf.ErrInvalidSyntax)
+		}
+	}
+
+	var v struct {
+		Tpl string `value:"${tpl}"`
+		Def string `value:"${missing:=$${x}}"`
+	}
+	if err := p.Bind(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Tpl != "hello ${user}, from go-conf" || v.Def != "${x}" {
+		t.Fatalf("got %v, expect %v", v, "unescaped values")
+	}
+}
+
+func FuzzResolve(f *testing.F) {
+	for _, s := range []string{
+		"${a}", "$${a}", "$$${a}", "${a:=${b:=x}}", "${b}", "${x:=}}",
+		"${upper:${a}}", "${join(1,2)}", "{${a}}", "$", "${",
+	} {
+		f.Add(s)
+	}
+	p := conf.New()
+	_ = p.Set("a", "1")
+	_ = p.Set("b", "${c}")
+	_ = p.Set("c", "${b}")
+	f.Fuzz(func(t *testing.T, s string) {
+		_, _ = p.Resolve(s) // must not panic or overflow the stack
+
+		// the escaped string is resolved to itself
+		escaped := strings.ReplaceAll(s, "${", "$${")
+		got, err := p.Resolve(escaped)
+		if err != nil {
+			t.Fatalf("%q: %v", escaped, err)
+		}
+		if got != s {
+			t.Fatalf("%q: got %q, expect %q", escaped, got, s)
+		}
+	})
 }
 
 func TestStorage(t *testing.T) {
@@ -1005,8 +1149,22 @@ func TestConfiguration(t *testing.T) {
 
 	{
 		var gotPoints [3]Point
+		err := p.Bind(&gotPoints, conf.Tag(`${points:=(1,2)|(3,4)|(5,6)}>>point`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectPoints := [3]Point{
+			{1, 2}, {3, 4}, {5, 6},
+		}
+		if gotPoints != expectPoints {
+			t.Fatalf("got %v, expect %v", gotPoints, expectPoints)
+		}
+	}
+
+	{
+		var gotPoints [2]Point
 		gotErr := p.Bind(&gotPoints, conf.Tag(`${points:=(1,2)|(3,4)|(5,6)}>>point`))
-		expectErr := errors.New("use slice instead of array")
+		expectErr := errors.New("array length 2 doesn't match 3 elements")
 		if !strings.Contains(fmt.Sprint(gotErr), expectErr.Error()) {
 			t.Fatalf("got %v, expect %v", gotErr, expectErr)
 		}
@@ -1031,10 +1189,10 @@ func TestConfiguration(t *testing.T) {
 	}
 
 	{
-		var i int
-		v := reflect.ValueOf(&i)
+		var ch chan int
+		v := reflect.ValueOf(&ch)
 		gotErr := p.Bind(v)
-		expectErr := errors.New("bind *int error, target should be value type")
+		expectErr := errors.New("bind *chan int error, target should be value type")
 		if !strings.Contains(fmt.Sprint(gotErr), expectErr.Error()) {
 			t.Fatalf("got %v, expect %v", gotErr, expectErr)
 		}
@@ -1328,3 +1486,2894 @@ func TestConfiguration(t *testing.T) {
 		}
 	}
 }
+
+func TestWatch(t *testing.T) {
+
+	dir := t.TempDir()
+	file := dir + "/app.properties"
+	if err := os.WriteFile(file, []byte("a=1"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	c := conf.NewConfiguration()
+	c.Env().Reset([]string{})
+	c.Args().Reset([]string{})
+	c.SetWorkDir(dir)
+	c.File().Add("app.properties", "app-${profile:=dev}.properties")
+	if err := c.SetProperty("x", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	type event struct {
+		old, new conf.ReadOnlyProperties
+		err      error
+	}
+	events := make(chan event, 10)
+	c.Subscribe(func(old, new conf.ReadOnlyProperties, err error) {
+		events <- event{old, new, err}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := c.Watch(ctx, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	next := func() event {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for refresh")
+		}
+		return event{}
+	}
+
+	{
+		e := next()
+		if e.err != nil || e.old != nil || e.new.Get("a") != "1" {
+			t.Fatalf("unexpect event %v", e)
+		}
+	}
+
+	{
+		if err := os.WriteFile(file, []byte("a=22"), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		e := next()
+		if e.err != nil || e.old.Get("a") != "1" || e.new.Get("a") != "22" {
+			t.Fatalf("unexpect event %v", e)
+		}
+	}
+
+	{
+		err := os.WriteFile(dir+"/app-dev.properties", []byte("b=2"), os.ModePerm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := next()
+		if e.err != nil || e.new.Get("a") != "22" || e.new.Get("b") != "2" {
+			t.Fatalf("unexpect event %v", e)
+		}
+	}
+
+	{
+		if err := os.WriteFile(file, []byte("a=3\nx.y=2"), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		e := next()
+		expectErr := "property 'x' is a value but 'x.y' wants other type"
+		if e.new != nil || fmt.Sprint(e.err) != expectErr {
+			t.Fatalf("unexpect event %v", e)
+		}
+		if got := c.Current().Get("a"); got != "22" {
+			t.Fatalf("got %v, expect %v", got, "22")
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+
+	newProperties := func(m map[string]interface{}) *conf.Properties {
+		p := conf.New()
+		if err := p.Merge(m); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	old := newProperties(map[string]interface{}{
+		"db": map[string]interface{}{
+			"host": "127.0.0.1",
+			"port": 3306,
+		},
+		"server": map[string]interface{}{
+			"ports": []int{80, 443},
+		},
+	})
+
+	new := newProperties(map[string]interface{}{
+		"db": map[string]interface{}{
+			"host": "10.0.0.1",
+			"user": "root",
+		},
+		"server": map[string]interface{}{
+			"ports": []int{80},
+		},
+	})
+
+	{
+		got := conf.Diff(old, new)
+		expect := []conf.Change{
+			{Key: "db.host", Type: conf.ChangeModified, Old: "127.0.0.1", New: "10.0.0.1"},
+			{Key: "db.port", Type: conf.ChangeRemoved, Old: "3306"},
+			{Key: "db.user", Type: conf.ChangeAdded, New: "root"},
+			{Key: "server.ports[1]", Type: conf.ChangeRemoved, Old: "443"},
+		}
+		if !reflect.DeepEqual(got, expect) {
+			t.Fatalf("got %v, expect %v", got, expect)
+		}
+	}
+
+	{
+		got := conf.Diff(nil, old)
+		if len(got) != 4 || got[0].Type != conf.ChangeAdded {
+			t.Fatalf("got %v", got)
+		}
+		if got = conf.Diff(old, old.Copy()); len(got) != 0 {
+			t.Fatalf("got %v", got)
+		}
+	}
+
+	{
+		c := conf.NewConfiguration()
+		c.Env().Reset([]string{})
+		c.Args().Reset([]string{})
+
+		var (
+			dbChanges    []conf.Change
+			portChanges  []conf.Change
+			allChanges   []conf.Change
+			emptyChanges []conf.Change
+		)
+		c.SubscribeKeys("db.", func(changes []conf.Change) {
+			dbChanges = append(dbChanges, changes...)
+		})
+		c.SubscribeKeys("server.ports[*]", func(changes []conf.Change) {
+			portChanges = append(portChanges, changes...)
+		})
+		c.SubscribeKeys("", func(changes []conf.Change) {
+			allChanges = append(allChanges, changes...)
+		})
+		c.SubscribeKeys("server.port", func(changes []conf.Change) {
+			emptyChanges = append(emptyChanges, changes...)
+		})
+
+		dir := t.TempDir()
+		c.SetWorkDir(dir)
+		c.File().Add("app.properties")
+
+		for _, p := range []*conf.Properties{old, new} {
+			var sb strings.Builder
+			for _, k := range p.Keys() {
+				sb.WriteString(k + "=" + p.Get(k) + "\n")
+			}
+			err := os.WriteFile(dir+"/app.properties", []byte(sb.String()), os.ModePerm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = c.Refresh(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		expectDB := []string{"db.host", "db.port", "db.host", "db.port", "db.user"}
+		if got := changedKeys(dbChanges); !slices.Equal(got, expectDB) {
+			t.Fatalf("got %v, expect %v", got, expectDB)
+		}
+		expectPorts := []string{"server.ports[0]", "server.ports[1]", "server.ports[1]"}
+		if got := changedKeys(portChanges); !slices.Equal(got, expectPorts) {
+			t.Fatalf("got %v, expect %v", got, expectPorts)
+		}
+		if len(allChanges) != 8 {
+			t.Fatalf("got %v", allChanges)
+		}
+		if len(emptyChanges) != 0 {
+			t.Fatalf("got %v", emptyChanges)
+		}
+	}
+}
+
+func changedKeys(changes []conf.Change) []string {
+	var keys []string
+	for _, c := range changes {
+		keys = append(keys, c.Key)
+	}
+	return keys
+}
+
+func TestDynamic(t *testing.T) {
+
+	dir := t.TempDir()
+	writeFile := func(s string) {
+		if err := os.WriteFile(dir+"/app.properties", []byte(s), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := conf.NewConfiguration()
+	c.Env().Reset([]string{})
+	c.Args().Reset([]string{})
+	c.SetWorkDir(dir)
+	c.File().Add("app.properties")
+
+	type Server struct {
+		Name  string                 `value:"${name}"`
+		Port  conf.Dynamic[int]      `value:"${port}" expr:"$>0"`
+		Hosts conf.Dynamic[[]string] `value:"${hosts:=a,b}"`
+		Tags  conf.Dynamic[map[string]string]
+	}
+
+	{
+		var s Server
+		gotErr := c.Bind(&s)
+		expectErr := errors.New("configuration hasn't been refreshed")
+		if fmt.Sprint(gotErr) != expectErr.Error() {
+			t.Fatalf("got %v, expect %v", gotErr, expectErr)
+		}
+	}
+
+	writeFile("name=abc\nport=8080\ntags.a=1")
+	if _, err := c.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	var s Server
+	if err := c.Bind(&s); err != nil {
+		t.Fatal(err)
+	}
+
+	var dynPort conf.Dynamic[int]
+	if err := c.Bind(&dynPort, conf.Key("port")); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(port int, hosts []string, tags map[string]string) {
+		t.Helper()
+		if s.Name != "abc" {
+			t.Fatalf("got %v, expect %v", s.Name, "abc")
+		}
+		if got := s.Port.Value(); got != port {
+			t.Fatalf("got %v, expect %v", got, port)
+		}
+		if got := s.Hosts.Value(); !slices.Equal(got, hosts) {
+			t.Fatalf("got %v, expect %v", got, hosts)
+		}
+		if got := s.Tags.Value(); !maps.Equal(got, tags) {
+			t.Fatalf("got %v, expect %v", got, tags)
+		}
+		if got := dynPort.Value(); got != port {
+			t.Fatalf("got %v, expect %v", got, port)
+		}
+	}
+
+	check(8080, []string{"a", "b"}, map[string]string{"a": "1"})
+
+	writeFile("name=xyz\nport=9090\nhosts=c\ntags.b=2")
+	if _, err := c.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	check(9090, []string{"c"}, map[string]string{"b": "2"})
+
+	writeFile("name=xyz\nport=-1\nhosts=d")
+	_, gotErr := c.Refresh()
+	expectErr := errors.New(`validate failed on "$>0" for value -1`)
+	if !strings.Contains(fmt.Sprint(gotErr), expectErr.Error()) {
+		t.Fatalf("got %v, expect %v", gotErr, expectErr)
+	}
+	check(9090, []string{"c"}, map[string]string{"b": "2"})
+	if got := c.Current().Get("port"); got != "9090" {
+		t.Fatalf("got %v, expect %v", got, "9090")
+	}
+
+	{
+		writeFile("name=xyz\nport=9090\nhosts=c")
+		if _, err := c.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+		var r refreshCounter
+		for i := 0; i < 3; i++ {
+			if err := c.Bind(&r, conf.Key("port")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := c.Bind(&r, conf.Key("hosts")); err != nil {
+			t.Fatal(err)
+		}
+		r.keys = nil
+		if _, err := c.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+		if expect := []string{"hosts"}; !slices.Equal(r.keys, expect) {
+			t.Fatalf("got %v, expect %v", r.keys, expect)
+		}
+	}
+}
+
+// refreshCounter records the keys it's refreshed with.
+type refreshCounter struct {
+	keys []string
+}
+
+func (r *refreshCounter) OnRefresh(p *conf.Properties, param conf.BindParam) (func(), error) {
+	return func() { r.keys = append(r.keys, param.Key) }, nil
+}
+
+func TestOrigin(t *testing.T) {
+
+	dir := t.TempDir()
+	for name, s := range map[string]string{
+		"a.properties": "key=file-a\nfile.only=1",
+		"b.yaml":       "key: file-b",
+		"c.properties": "key=dync",
+	} {
+		if err := os.WriteFile(dir+"/"+name, []byte(s), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := conf.NewConfiguration()
+	c.SetWorkDir(dir)
+	c.File().Add("a.properties", "b.yaml")
+	c.Env().Reset([]string{"GS_KEY=env"})
+	c.Args().Reset([]string{"-D", "key=args"})
+	c.Dync().Add("c.properties")
+	if err := c.SetProperty("key", "prop"); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := c.Refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	{
+		got := p.Origins("key")
+		expect := []conf.Origin{
+			{Layer: "prop", Value: "prop"},
+			{Layer: "file", Source: dir + "/a.properties", Value: "file-a"},
+			{Layer: "file", Source: dir + "/b.yaml", Value: "file-b"},
+			{Layer: "env", Source: "GS_KEY", Value: "env"},
+			{Layer: "args", Source: "-D key=args", Value: "args"},
+			{Layer: "dync", Source: dir + "/c.properties", Value: "dync"},
+		}
+		if !reflect.DeepEqual(got, expect) {
+			t.Fatalf("got %v, expect %v", got, expect)
+		}
+	}
+
+	{
+		got, ok := p.Origin("file.only")
+		expect := conf.Origin{Layer: "file", Source: dir + "/a.properties", Value: "1"}
+		if !ok || got != expect {
+			t.Fatalf("got %v, expect %v", got, expect)
+		}
+		if got.String() != "file:"+dir+"/a.properties" {
+			t.Fatalf("got %v", got.String())
+		}
+	}
+
+	{
+		if _, ok := p.Origin("not.exist"); ok {
+			t.Fatal("should not exist")
+		}
+		if got := p.Origins("not.exist"); got != nil {
+			t.Fatalf("got %v", got)
+		}
+	}
+
+	{
+		p := conf.New()
+		if err = p.Set("key", "abc"); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := p.Origin("key"); ok {
+			t.Fatal("origin should not be recorded")
+		}
+	}
+
+	{
+		file := dir + "/d.toml"
+		s := "title = \"x\"\n\n[db]\nhost = \"h\"\nports = [1, 2]\n\n[[servers]]\nname = \"a\"\n"
+		if err = os.WriteFile(file, []byte(s), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		c := conf.NewConfiguration()
+		c.File().Add(file)
+		c.Env().Reset([]string{})
+		c.Args().Reset([]string{})
+		p, err := c.Refresh()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key, line := range map[string]int{
+			"title":           1,
+			"db.host":         4,
+			"db.ports[1]":     5,
+			"servers[0].name": 8,
+		} {
+			o, ok := p.Origin(key)
+			if !ok || o.Line != line || o.Source != file {
+				t.Fatalf("%s: got %v, expect %v", key, o, line)
+			}
+		}
+		if o, _ := p.Origin("db.host"); o.String() != "file:"+file+":4" {
+			t.Fatalf("got %v", o.String())
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+
+	p := conf.New()
+	for _, file := range []string{"testdata/conf.json", "testdata/conf.toml", "testdata/conf-online.yaml"} {
+		if err := p.Load(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := p.Set("objs", []map[string]interface{}{
+		{"name": "a", "tags": []string{"x", "y"}},
+		{"name": "b=c\nd"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	{
+		got := p.Tree()["json"].(map[string]interface{})
+		expect := map[string]interface{}{
+			"int": "1",
+			"str": "abc",
+			"arr": []interface{}{"a", "b", "c"},
+			"map": map[string]interface{}{
+				"a": "1",
+				"b": "2",
+			},
+			"empty_arr": nil,
+			"empty_map": nil,
+		}
+		if !reflect.DeepEqual(got, expect) {
+			t.Fatalf("got %v, expect %v", got, expect)
+		}
+	}
+
+	dir := t.TempDir()
+	for _, ext := range []string{".json", ".properties", ".yaml", ".toml"} {
+		b1, err := p.Marshal(ext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b2, err := p.Marshal(ext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b1) != string(b2) {
+			t.Fatalf("%s isn't deterministic", ext)
+		}
+		file := dir + "/conf" + ext
+		if err = p.Save(file); err != nil {
+			t.Fatal(err)
+		}
+		r := conf.New()
+		if err = r.Load(file); err != nil {
+			t.Fatal(err)
+		}
+		if got, expect := r.Data(), p.Data(); !maps.Equal(got, expect) {
+			t.Fatalf("%s: got %v, expect %v", ext, got, expect)
+		}
+	}
+
+	{
+		_, gotErr := p.Marshal(".unknown_ext")
+		expectErr := errors.New(`unsupported file type ".unknown_ext"`)
+		if fmt.Sprint(gotErr) != expectErr.Error() {
+			t.Fatalf("got %v, expect %v", gotErr, expectErr)
+		}
+	}
+}
+
+func TestUnbind(t *testing.T) {
+
+	type DB struct {
+		Host     string        `value:"${host:=127.0.0.1}"`
+		Port     int           `value:"${port:=3306}"`
+		Timeout  time.Duration `value:"${timeout:=5s}"`
+		Max_Idle int
+		Ratio    float32  `value:"${ratio:=0.5}"`
+		Hosts    []string `value:"${hosts:=a,b}"`
+	}
+
+	type Base struct {
+		Name string `value:"${name:=app}"`
+	}
+
+	type Config struct {
+		Base
+		DB      DB                `value:"${db}"`
+		Replica []DB              `value:"${replicas:=}"`
+		Labels  map[string]string `value:"${labels:=}"`
+		Start   time.Time         `value:"${start}"`
+		Debug   bool
+	}
+
+	{
+		p, err := conf.FromStruct(Config{}, conf.Defaults())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := p.Data()
+		expect := map[string]string{
+			"name":        "app",
+			"db.host":     "127.0.0.1",
+			"db.port":     "3306",
+			"db.timeout":  "5s",
+			"db.max.idle": "0",
+			"db.ratio":    "0.5",
+			"db.hosts":    "a,b",
+			"replicas":    "",
+			"labels":      "",
+			"start":       "0001-01-01T00:00:00Z",
+			"debug":       "false",
+		}
+		if !maps.Equal(got, expect) {
+			t.Fatalf("got %v, expect %v", got, expect)
+		}
+
+		var c Config
+		if err = p.Bind(&c); err != nil {
+			t.Fatal(err)
+		}
+		if c.DB.Timeout != 5*time.Second || !slices.Equal(c.DB.Hosts, []string{"a", "b"}) {
+			t.Fatalf("got %v", c)
+		}
+	}
+
+	{
+		start := time.Date(2024, 10, 1, 8, 0, 0, 0, time.UTC)
+		c := Config{
+			Base: Base{Name: "svc"},
+			DB: DB{
+				Host:     "10.0.0.1",
+				Timeout:  time.Minute,
+				Max_Idle: 2,
+				Hosts:    []string{"x"},
+			},
+			Replica: []DB{{Host: "10.0.0.2", Port: 3307, Hosts: []string{}}},
+			Labels:  map[string]string{"b": "2", "a": "1"},
+			Start:   start,
+			Debug:   true,
+		}
+		p := conf.New()
+		if err := p.Unbind(&c, conf.Key("app")); err != nil {
+			t.Fatal(err)
+		}
+
+		var got Config
+		if err := p.Bind(&got, conf.Key("app")); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, c) {
+			t.Fatalf("got %v, expect %v", got, c)
+		}
+	}
+
+	{
+		type Sub struct {
+			Size int `value:"${size:=4}"`
+		}
+		type Server struct {
+			Enabled bool `value:"${enabled:=true}"`
+			Port    int  `value:"${port:=8080}"`
+			Sub     Sub  `value:"${sub:=}"`
+		}
+		p, err := conf.FromStruct(Server{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := map[string]string{"enabled": "false", "port": "0", "sub.size": "0"}
+		if got := p.Data(); !maps.Equal(got, expect) {
+			t.Fatalf("got %v, expect %v", got, expect)
+		}
+		var s Server
+		if err = p.Bind(&s); err != nil {
+			t.Fatal(err)
+		}
+		if s != (Server{}) {
+			t.Fatalf("got %v, expect %v", s, Server{})
+		}
+
+		p, err = conf.FromStruct(Server{}, conf.Defaults())
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect = map[string]string{"enabled": "true", "port": "8080", "sub.size": "4"}
+		if got := p.Data(); !maps.Equal(got, expect) {
+			t.Fatalf("got %v, expect %v", got, expect)
+		}
+	}
+
+	{
+		var obj struct {
+			Port conf.Dynamic[int] `value:"${port:=8080}"`
+			Max  conf.Dynamic[int] `value:"${max:=10}"`
+		}
+		if err := conf.New().Bind(&obj.Max, conf.Key("max:=20")); err != nil {
+			t.Fatal(err)
+		}
+		p, err := conf.FromStruct(&obj, conf.Defaults())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := p.Data()
+		expect := map[string]string{"port": "8080", "max": "20"}
+		if !maps.Equal(got, expect) {
+			t.Fatalf("got %v, expect %v", got, expect)
+		}
+	}
+
+	{
+		type Point struct {
+			X, Y int
+		}
+		var obj struct {
+			Point Point `value:"${point}"`
+		}
+		conf.RegisterConverter(func(s string) (Point, error) {
+			return Point{}, nil
+		})
+		_, gotErr := conf.FromStruct(&obj)
+		expectErr := errors.New("can't find formatter for conf_test.Point")
+		if !strings.Contains(fmt.Sprint(gotErr), expectErr.Error()) {
+			t.Fatalf("got %v, expect %v", gotErr, expectErr)
+		}
+		conf.RegisterFormatter(func(p Point) (string, error) {
+			return fmt.Sprintf("(%d,%d)", p.X, p.Y), nil
+		})
+		obj.Point = Point{1, 2}
+		p, err := conf.FromStruct(&obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Get("point"); got != "(1,2)" {
+			t.Fatalf("got %v, expect %v", got, "(1,2)")
+		}
+	}
+
+	{
+		defer func() {
+			r := recover()
+			expect := "formatter is func(type)(string,error)"
+			if fmt.Sprint(r) != expect {
+				t.Fatal(r)
+			}
+		}()
+		conf.RegisterFormatter(func() {})
+	}
+}
+
+func TestCollectErrors(t *testing.T) {
+
+	p := conf.New()
+	err := p.Merge(map[string]interface{}{
+		"port": "abc",
+		"age":  "3",
+		"db": map[string]interface{}{
+			"hosts": []map[string]interface{}{
+				{"addr": "a", "port": "x"},
+				{"addr": "b", "port": "3306"},
+			},
+		},
+		"tags": map[string]interface{}{
+			"a": "1",
+			"b": "b",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type Host struct {
+		Addr string `value:"${addr}"`
+		Port int    `value:"${port}"`
+	}
+
+	type Config struct {
+		Port  int            `value:"${port}"`
+		Name  string         `value:"${name}"`
+		Age   int            `value:"${age}" expr:"$>=18"`
+		Hosts []Host         `value:"${db.hosts}"`
+		Tags  map[string]int `value:"${tags}"`
+		Time  time.Duration  `value:"${time:=3x}"`
+	}
+
+	{
+		var c Config
+		gotErr := p.Bind(&c)
+		expectErr := errors.New(`parsing "abc": invalid syntax`)
+		if !strings.Contains(fmt.Sprint(gotErr), expectErr.Error()) {
+			t.Fatalf("got %v, expect %v", gotErr, expectErr)
+		}
+	}
+
+	var c Config
+	gotErr := p.Bind(&c, conf.CollectErrors())
+
+	var errs *conf.BindErrors
+	if !errors.As(gotErr, &errs) {
+		t.Fatalf("got %v, expect *conf.BindErrors", gotErr)
+	}
+	if !errors.Is(gotErr, conf.ErrNotExist) {
+		t.Fatalf("got %v, expect %v", gotErr, conf.ErrNotExist)
+	}
+
+	type result struct {
+		Path, Key, Err string
+	}
+	expect := []result{
+		{"Config.Port", "port", `parsing "abc": invalid syntax`},
+		{"Config.Name", "name", `property "name" not exist`},
+		{"Config.Age", "age", `validate failed on "$>=18" for value 3`},
+		{"Config.Hosts[0].Port", "db.hosts[0].port", `parsing "x": invalid syntax`},
+		{"Config.Tags", "tags.b", `parsing "b": invalid syntax`},
+		{"Config.Time", "time", `unknown unit "x" in duration "3x"`},
+	}
+	if len(errs.Errors) != len(expect) {
+		t.Fatalf("got %v, expect %v", errs, expect)
+	}
+	for i, e := range errs.Errors {
+		if e.Path != expect[i].Path || e.Key != expect[i].Key || !strings.Contains(e.Err.Error(), expect[i].Err) {
+			t.Fatalf("got %v, expect %v", e, expect[i])
+		}
+	}
+	if c.Hosts[0].Addr != "b" || c.Tags["a"] != 1 {
+		t.Fatalf("got %v", c)
+	}
+
+	{
+		var i int
+		gotErr = p.Bind(&i, conf.Key("port"), conf.CollectErrors())
+		if !errors.As(gotErr, &errs) || len(errs.Errors) != 1 || errs.Errors[0].Key != "port" {
+			t.Fatalf("got %v", gotErr)
+		}
+	}
+}
+
+func TestBindPointer(t *testing.T) {
+
+	p := conf.New()
+	err := p.Merge(map[string]interface{}{
+		"port": "8080",
+		"db": map[string]interface{}{
+			"host": "127.0.0.1",
+		},
+		"hosts": []map[string]interface{}{
+			{"host": "a"},
+			{"host": "b"},
+		},
+		"ports": []int{80, 443},
+		"nodes": map[string]interface{}{
+			"a": map[string]interface{}{"host": "x"},
+		},
+		"extra": map[string]interface{}{
+			"list": []string{"${port}", "b"},
+			"name": "n",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type DB struct {
+		Host string `value:"${host}"`
+	}
+
+	type Config struct {
+		Port     *int           `value:"${port}"`
+		Name     *string        `value:"${name}"`
+		Timeout  *int           `value:"${timeout:=3}"`
+		DB       *DB            `value:"${db}"`
+		Cache    *DB            `value:"${cache}"`
+		Hosts    []*DB          `value:"${hosts}"`
+		Ports    [2]int         `value:"${ports}"`
+		Empty    [2]int         `value:"${empty:=}"`
+		Nodes    map[string]*DB `value:"${nodes}"`
+		Extra    interface{}    `value:"${extra}"`
+		Missing  interface{}    `value:"${missing}"`
+		Default  interface{}    `value:"${default:=${port}}"`
+		PtrPorts []*int         `value:"${ports}"`
+		Values   map[string]any `value:"${db}"`
+		Splits   [3]*int        `value:"${splits:=1,2,3}"`
+		Untagged *DB
+	}
+
+	var c Config
+	if err = p.Bind(&c); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Port == nil || *c.Port != 8080 {
+		t.Fatalf("got %v, expect %v", c.Port, 8080)
+	}
+	if c.Name != nil {
+		t.Fatalf("got %v, expect nil", *c.Name)
+	}
+	if c.Timeout == nil || *c.Timeout != 3 {
+		t.Fatalf("got %v, expect %v", c.Timeout, 3)
+	}
+	if c.DB == nil || c.DB.Host != "127.0.0.1" {
+		t.Fatalf("got %v", c.DB)
+	}
+	if c.Cache != nil || c.Untagged != nil {
+		t.Fatalf("got %v %v, expect nil", c.Cache, c.Untagged)
+	}
+	if len(c.Hosts) != 2 || c.Hosts[0].Host != "a" || c.Hosts[1].Host != "b" {
+		t.Fatalf("got %v", c.Hosts)
+	}
+	if c.Ports != [2]int{80, 443} || c.Empty != [2]int{} {
+		t.Fatalf("got %v %v", c.Ports, c.Empty)
+	}
+	if len(c.Nodes) != 1 || c.Nodes["a"].Host != "x" {
+		t.Fatalf("got %v", c.Nodes)
+	}
+	expectExtra := map[string]interface{}{
+		"list": []interface{}{"8080", "b"},
+		"name": "n",
+	}
+	if !reflect.DeepEqual(c.Extra, expectExtra) {
+		t.Fatalf("got %v, expect %v", c.Extra, expectExtra)
+	}
+	if c.Missing != nil || c.Default != "8080" {
+		t.Fatalf("got %v %v", c.Missing, c.Default)
+	}
+	if len(c.PtrPorts) != 2 || *c.PtrPorts[1] != 443 {
+		t.Fatalf("got %v", c.PtrPorts)
+	}
+	if !reflect.DeepEqual(c.Values, map[string]any{"host": "127.0.0.1"}) {
+		t.Fatalf("got %v", c.Values)
+	}
+	if *c.Splits[0] != 1 || *c.Splits[2] != 3 {
+		t.Fatalf("got %v", c.Splits)
+	}
+
+	{
+		r, err := conf.FromStruct(&c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := map[string]string{
+			"port":          "8080",
+			"timeout":       "3",
+			"db.host":       "127.0.0.1",
+			"hosts[1].host": "b",
+			"ports[1]":      "443",
+			"extra.list[0]": "8080",
+			"nodes.a.host":  "x",
+		}
+		for key, value := range expect {
+			if got := r.Get(key); got != value {
+				t.Fatalf("%s: got %v, expect %v", key, got, value)
+			}
+		}
+		if r.Has("name") || r.Has("cache") || r.Has("missing") {
+			t.Fatalf("got %v", r.Data())
+		}
+	}
+}
+
+// level implements flag.Value.
+type level int
+
+func (l *level) String() string { return strconv.Itoa(int(*l)) }
+
+func (l *level) Set(s string) error {
+	switch s {
+	case "low":
+		*l = 1
+	case "high":
+		*l = 2
+	default:
+		return fmt.Errorf("unknown level %q", s)
+	}
+	return nil
+}
+
+// endpoint implements json.Unmarshaler.
+type endpoint struct {
+	Host string
+	Port int
+}
+
+func (e *endpoint) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		type plain endpoint
+		return json.Unmarshal(b, (*plain)(e))
+	}
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return err
+	}
+	e.Host = host
+	e.Port, err = strconv.Atoi(port)
+	return err
+}
+
+// pool implements encoding.TextUnmarshaler, it rejects an empty text.
+type pool struct {
+	Size int `value:"${size:=4}"`
+}
+
+func (p *pool) UnmarshalText(b []byte) error {
+	n, err := strconv.Atoi(string(b))
+	p.Size = n
+	return err
+}
+
+func TestUnmarshaler(t *testing.T) {
+
+	type Config struct {
+		IP       net.IP       `value:"${ip}"`
+		Addr     netip.Addr   `value:"${addr}"`
+		Addrs    []netip.Addr `value:"${addrs:=10.0.0.1,10.0.0.2}"`
+		Big      *big.Int     `value:"${big}"`
+		LogLevel slog.Level   `value:"${log-level:=warn}"`
+		Level    level        `value:"${level}"`
+		Endpoint endpoint     `value:"${endpoint}"`
+		Server   endpoint     `value:"${server}"`
+	}
+
+	p := conf.New()
+	err := p.Merge(map[string]interface{}{
+		"ip":       "192.168.0.1",
+		"addr":     "::1",
+		"big":      "123456789012345678901234567890",
+		"level":    "high",
+		"endpoint": "localhost:8080",
+		"server":   `{"Host":"example.com","Port":443}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var c Config
+	if err = p.Bind(&c); err != nil {
+		t.Fatal(err)
+	}
+
+	if !c.IP.Equal(net.ParseIP("192.168.0.1")) {
+		t.Fatalf("got %v, expect %v", c.IP, "192.168.0.1")
+	}
+	if c.Addr != netip.MustParseAddr("::1") {
+		t.Fatalf("got %v, expect %v", c.Addr, "::1")
+	}
+	if len(c.Addrs) != 2 || c.Addrs[1].String() != "10.0.0.2" {
+		t.Fatalf("got %v", c.Addrs)
+	}
+	if c.Big.String() != "123456789012345678901234567890" {
+		t.Fatalf("got %v", c.Big)
+	}
+	if c.LogLevel != slog.LevelWarn {
+		t.Fatalf("got %v, expect %v", c.LogLevel, slog.LevelWarn)
+	}
+	if c.Level != 2 {
+		t.Fatalf("got %v, expect %v", c.Level, 2)
+	}
+	if c.Endpoint != (endpoint{"localhost", 8080}) {
+		t.Fatalf("got %v", c.Endpoint)
+	}
+	if c.Server != (endpoint{"example.com", 443}) {
+		t.Fatalf("got %v", c.Server)
+	}
+
+	{
+		r := conf.New()
+		err = r.Unbind(struct {
+			IP   net.IP     `value:"${ip}"`
+			Addr netip.Addr `value:"${addr}"`
+			Big  *big.Int   `value:"${big}"`
+		}{c.IP, c.Addr, c.Big})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []string{"ip", "addr", "big"} {
+			if got, expect := r.Get(key), p.Get(key); got != expect {
+				t.Fatalf("%s: got %v, expect %v", key, got, expect)
+			}
+		}
+	}
+
+	{
+		p := conf.New()
+		_ = p.Set("db.host", "db.local")
+		_ = p.Set("db.port", "5432")
+		var s struct {
+			DB endpoint `value:"${db}"`
+		}
+		if err = p.Bind(&s); err != nil {
+			t.Fatal(err)
+		}
+		if s.DB != (endpoint{"db.local", 5432}) {
+			t.Fatalf("got %v, expect %v", s.DB, "bound from sub keys")
+		}
+	}
+
+	{
+		p := conf.New()
+		var s struct {
+			Pool pool `value:"${pool:=}"`
+		}
+		if err = p.Bind(&s); err != nil {
+			t.Fatal(err)
+		}
+		if s.Pool != (pool{Size: 4}) {
+			t.Fatalf("got %v, expect %v", s, "bound from field defaults")
+		}
+	}
+
+	{
+		p := conf.New()
+		_ = p.Set("level", "middle")
+		var s struct {
+			Level level `value:"${level}"`
+		}
+		err = p.Bind(&s)
+		if err == nil || !strings.Contains(err.Error(), `unknown level "middle"`) {
+			t.Fatalf("got %v", err)
+		}
+	}
+}
+
+func TestBinder(t *testing.T) {
+
+	// reflectConfig has no generated methods except the promoted ones, so
+	// it's bound by reflection.
+	type reflectConfig example.Config
+
+	newProperties := func(m map[string]interface{}) *conf.Properties {
+		p := conf.New()
+		if err := p.Merge(m); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	{
+		p := newProperties(map[string]interface{}{
+			"name":  "app",
+			"debug": "true",
+			"log":   map[string]interface{}{"dir": "/var/log"},
+			"tags":  []string{"x", "y", "z"},
+			"main":  map[string]interface{}{"host": "a", "port": "80", "timeout": "1s"},
+			"servers": []map[string]interface{}{
+				{"port": "81"},
+				{"host": "c", "port": "82"},
+			},
+			"labels": map[string]interface{}{"k": "v"},
+			"backup": map[string]interface{}{"port": "83"},
+			"retry":  "3",
+		})
+
+		var c example.Config
+		if err := p.Bind(&c); err != nil {
+			t.Fatal(err)
+		}
+		var r reflectConfig
+		if err := p.Bind(&r); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(c, example.Config(r)) {
+			t.Fatalf("got %v, expect %v", c, r)
+		}
+
+		var g example.Config
+		if err := g.BindFrom(p); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(c, g) {
+			t.Fatalf("got %v, expect %v", g, c)
+		}
+
+		if !c.Debug || c.Log_Dir != "/var/log" || c.Ratio != 0.5 || c.Retry != 3 {
+			t.Fatalf("got %v", c)
+		}
+		if c.Main.Timeout != time.Second || c.Servers[0].Host != "localhost" || c.Backup.Port != 83 {
+			t.Fatalf("got %v", c)
+		}
+	}
+
+	{
+		p := newProperties(map[string]interface{}{
+			"name":    "app",
+			"main":    map[string]interface{}{"port": "0"},
+			"servers": []map[string]interface{}{{"port": "x"}},
+		})
+
+		var c example.Config
+		err := p.Bind(&c, conf.CollectErrors())
+		var r reflectConfig
+		expect := p.Bind(&r, conf.CollectErrors())
+
+		var e1, e2 *conf.BindErrors
+		if !errors.As(err, &e1) || !errors.As(expect, &e2) {
+			t.Fatalf("got %v, expect %v", err, expect)
+		}
+		if len(e1.Errors) != 4 || len(e1.Errors) != len(e2.Errors) {
+			t.Fatalf("got %v, expect %v", err, expect)
+		}
+		for i := range e1.Errors {
+			if e1.Errors[i].Key != e2.Errors[i].Key {
+				t.Fatalf("got %v, expect %v", e1.Errors[i].Key, e2.Errors[i].Key)
+			}
+		}
+		if !strings.Contains(err.Error(), "validate failed") {
+			t.Fatalf("got %v", err)
+		}
+	}
+
+	{
+		type App struct {
+			example.Options
+			Port int `value:"${port}"`
+		}
+		p := newProperties(map[string]interface{}{
+			"debug": "true",
+			"log":   map[string]interface{}{"dir": "/var/log"},
+			"port":  "8080",
+		})
+		var a App
+		if err := p.Bind(&a); err != nil {
+			t.Fatal(err)
+		}
+		if !a.Debug || a.Port != 8080 {
+			t.Fatalf("got %v", a)
+		}
+	}
+}
+
+func BenchmarkBinder(b *testing.B) {
+
+	type reflectConfig example.Config
+
+	p := conf.New()
+	_ = p.Set("name", "app")
+	_ = p.Set("log.dir", "/var/log")
+	_ = p.Set("retry", "3")
+	_ = p.Set("main.port", "80")
+	for i := 0; i < 20; i++ {
+		_ = p.Set(fmt.Sprintf("servers[%d].port", i), strconv.Itoa(8000+i))
+	}
+
+	b.Run("generated", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var c example.Config
+			if err := p.Bind(&c); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("reflection", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var c reflectConfig
+			if err := p.Bind(&c); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestProfiles(t *testing.T) {
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"app.yaml": `name: base
+port: 80
+---
+spring.config.activate.on-profile: dev
+port: 81
+---
+spring:
+  config:
+    activate:
+      on-profile: [prod, test]
+port: 82
+`,
+		"app-dev.yaml":       "name: dev\nlevel: debug\n",
+		"app-prod.yaml":      "name: prod\nlevel: warn\n",
+		"db.properties":      "db.host=localhost\n",
+		"db-prod.properties": "db.host=prod-db\n",
+		"other-prod.json":    `{"other": "prod"}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(dir+"/"+name, []byte(content), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newConfiguration := func() *conf.Configuration {
+		c := conf.NewConfiguration()
+		c.Env().Reset([]string{})
+		c.Args().Reset([]string{})
+		c.SetWorkDir(dir)
+		c.File().Add("app.yaml", "db.properties", "other.json")
+		return c
+	}
+
+	check := func(c *conf.Configuration, expect map[string]string, profiles []string) {
+		t.Helper()
+		p, err := c.Refresh()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key, value := range expect {
+			if got := p.Get(key); got != value {
+				t.Fatalf("%s: got %q, expect %q", key, got, value)
+			}
+		}
+		if p.Has(conf.OnProfileKey) || p.Has("spring.config") {
+			t.Fatalf("got %v", p.Data())
+		}
+		if got := c.ActiveProfiles(); !slices.Equal(got, profiles) {
+			t.Fatalf("got %v, expect %v", got, profiles)
+		}
+	}
+
+	{
+		c := newConfiguration()
+		check(c, map[string]string{
+			"name":    "base",
+			"port":    "80",
+			"level":   "",
+			"db.host": "localhost",
+		}, nil)
+	}
+
+	{
+		c := newConfiguration()
+		_ = c.SetProperty(conf.ActiveProfilesKey, "dev")
+		check(c, map[string]string{
+			"name":  "dev",
+			"port":  "81",
+			"level": "debug",
+		}, []string{"dev"})
+		o, _ := c.Current().Origin("name")
+		if o.Source != dir+"/app-dev.yaml" {
+			t.Fatalf("got %v", o)
+		}
+	}
+
+	{
+		c := newConfiguration()
+		_ = c.SetProperty(conf.ActiveProfilesKey, "dev, prod")
+		check(c, map[string]string{
+			"name":    "prod",
+			"port":    "82",
+			"level":   "warn",
+			"db.host": "prod-db",
+			"other":   "prod",
+		}, []string{"dev", "prod"})
+	}
+
+	{
+		c := newConfiguration()
+		_ = c.SetProperty(conf.ActiveProfilesKey, "prod,dev")
+		check(c, map[string]string{
+			"name":    "dev",
+			"port":    "81",
+			"level":   "debug",
+			"db.host": "prod-db",
+		}, []string{"prod", "dev"})
+	}
+
+	{
+		c := newConfiguration()
+		c.Env().Reset([]string{"GS_SPRING_PROFILES_ACTIVE=test"})
+		check(c, map[string]string{
+			"name": "base",
+			"port": "82",
+		}, []string{"test"})
+	}
+
+	{
+		c := newConfiguration()
+		_ = c.SetProperty(conf.ActiveProfilesKey, "dev")
+		c.Args().Reset([]string{"-D", "spring.profiles.active=prod"})
+		check(c, map[string]string{
+			"name": "prod",
+			"port": "82",
+		}, []string{"prod"})
+	}
+}
+
+func TestImport(t *testing.T) {
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"app.yaml": `name: app
+port: 80
+conf.import:
+  - sub/db.yaml
+  - optional:missing.yaml
+  - ${profile}.properties
+`,
+		"sub/db.yaml":     "db.host: localhost\nport: 81\nconf.import: ../common.json\n",
+		"common.json":     `{"common": "c"}`,
+		"prod.properties": "level=warn\n",
+		"required.yaml":   "conf.import: missing.yaml\n",
+		"a.yaml":          "a: 1\nconf.import: b.yaml\n",
+		"b.yaml":          "b: 2\nconf.import: sub/../a.yaml\n",
+	}
+	if err := os.Mkdir(dir+"/sub", os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(dir+"/"+name, []byte(content), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newConfiguration := func(file string) *conf.Configuration {
+		c := conf.NewConfiguration()
+		c.Env().Reset([]string{})
+		c.Args().Reset([]string{})
+		c.SetWorkDir(dir)
+		c.File().Add(file)
+		_ = c.SetProperty("profile", "prod")
+		return c
+	}
+
+	{
+		p, err := newConfiguration("app.yaml").Refresh()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := map[string]string{
+			"name":    "app",
+			"port":    "81",
+			"db.host": "localhost",
+			"common":  "c",
+			"level":   "warn",
+		}
+		for key, value := range expect {
+			if got := p.Get(key); got != value {
+				t.Fatalf("%s: got %q, expect %q", key, got, value)
+			}
+		}
+		if p.Has(conf.ImportKey) || p.Has("conf") {
+			t.Fatalf("got %v", p.Data())
+		}
+		if o, _ := p.Origin("db.host"); o.Source != dir+"/sub/db.yaml" {
+			t.Fatalf("got %v", o)
+		}
+	}
+
+	{
+		_, err := newConfiguration("required.yaml").Refresh()
+		if err == nil || !strings.Contains(err.Error(), `import "missing.yaml"`) || !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("got %v", err)
+		}
+	}
+
+	{
+		_, err := newConfiguration("a.yaml").Refresh()
+		expect := fmt.Sprintf("import cycle %s/a.yaml -> %s/b.yaml -> %s/a.yaml", dir, dir, dir)
+		if err == nil || !strings.Contains(err.Error(), expect) {
+			t.Fatalf("got %v, expect %v", err, expect)
+		}
+	}
+}
+
+func TestLocations(t *testing.T) {
+
+	dir := t.TempDir()
+	for _, d := range []string{"conf.d", "extra", "empty"} {
+		if err := os.Mkdir(dir+"/"+d, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		"base.yaml":          "x: base\n",
+		"conf.d/b.yaml":      "x: b\n",
+		"conf.d/a.yaml":      "x: a\ny: a\n",
+		"conf.d/c.txt":       "x=c\n",
+		"extra/1.properties": "z=1\n",
+		"extra/2.json":       `{"z": "2"}`,
+		"extra/3.txt":        "z=3\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(dir+"/"+name, []byte(content), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newConfiguration := func() *conf.Configuration {
+		c := conf.NewConfiguration()
+		c.Env().Reset([]string{})
+		c.Args().Reset([]string{})
+		c.SetWorkDir(dir)
+		_ = c.SetProperty("d", "conf.d")
+		return c
+	}
+
+	{
+		c := newConfiguration()
+		c.File().Require("base.yaml", "${d}/*.yaml", "empty")
+		c.File().Add("extra", "missing.yaml", "missing-*.yaml")
+		p, err := c.Refresh()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := map[string]string{"x": "b", "y": "a", "z": "2"}
+		for key, value := range expect {
+			if got := p.Get(key); got != value {
+				t.Fatalf("%s: got %q, expect %q", key, got, value)
+			}
+		}
+	}
+
+	{
+		c := newConfiguration()
+		c.File().Require("missing.yaml")
+		_, err := c.Refresh()
+		expect := fmt.Sprintf(`location "missing.yaml" (resolved %s/missing.yaml) error`, dir)
+		if err == nil || !strings.Contains(err.Error(), expect) || !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("got %v, expect %v", err, expect)
+		}
+	}
+
+	{
+		c := newConfiguration()
+		c.File().Require("${d}/missing-*.yaml")
+		_, err := c.Refresh()
+		expect := fmt.Sprintf(`location "${d}/missing-*.yaml" (resolved %s/conf.d/missing-*.yaml) error, no file matches`, dir)
+		if err == nil || !strings.Contains(err.Error(), expect) || !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("got %v, expect %v", err, expect)
+		}
+	}
+
+	{
+		c := newConfiguration()
+		c.File().Add("${d}/*.yaml")
+
+		events := make(chan conf.ReadOnlyProperties, 10)
+		c.Subscribe(func(old, new conf.ReadOnlyProperties, err error) {
+			events <- new
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		if err := c.Watch(ctx, 10*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		<-events
+
+		if err := os.WriteFile(dir+"/conf.d/d.yaml", []byte("x: d\n"), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case p := <-events:
+			if got := p.Get("x"); got != "d" {
+				t.Fatalf("got %q, expect %q", got, "d")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for refresh")
+		}
+	}
+}
+
+type funcSource func(out *conf.Properties) error
+
+func (f funcSource) CopyTo(out *conf.Properties) error {
+	return f(out)
+}
+
+func TestLayers(t *testing.T) {
+
+	c := conf.NewConfiguration()
+	c.Env().Reset([]string{"DB_HOST=env"})
+	c.Args().Reset([]string{})
+	_ = c.SetProperty("db.host", "prop")
+
+	names := func() string {
+		var ss []string
+		for _, l := range c.Layers() {
+			s := l.Name
+			if !l.Enabled {
+				s = "!" + s
+			}
+			ss = append(ss, s)
+		}
+		return strings.Join(ss, ",")
+	}
+
+	{
+		if got := names(); got != "prop,file,env,args,dync" {
+			t.Fatalf("got %v, expect %v", got, "prop,file,env,args,dync")
+		}
+	}
+
+	fixture := conf.New()
+	_ = fixture.Set("db.host", "fixture")
+	_ = fixture.Set("db.port", "3306")
+
+	{
+		if err := c.InsertLayerAfter("file", "fixture", fixture); err != nil {
+			t.Fatal(err)
+		}
+		p, err := c.Refresh()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Get("db.host"); got != "env" {
+			t.Fatalf("got %v, expect %v", got, "env")
+		}
+		if o, _ := p.Origin("db.port"); o.Layer != "fixture" {
+			t.Fatalf("got %v, expect %v", o.Layer, "fixture")
+		}
+	}
+
+	{
+		if err := c.EnableLayer("env", false); err != nil {
+			t.Fatal(err)
+		}
+		p, err := c.Refresh()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Get("db.host"); got != "fixture" {
+			t.Fatalf("got %v, expect %v", got, "fixture")
+		}
+		if got := names(); got != "prop,file,fixture,!env,args,dync" {
+			t.Fatalf("got %v, expect %v", got, "prop,file,fixture,!env,args,dync")
+		}
+	}
+
+	{
+		vault := funcSource(func(out *conf.Properties) error {
+			return out.Set("db.password", "secret")
+		})
+		if err := c.AddLayer("vault", vault); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.InsertLayerBefore("prop", "defaults", funcSource(func(out *conf.Properties) error {
+			return out.Set("db", map[string]string{"host": "localhost", "user": "root"})
+		})); err != nil {
+			t.Fatal(err)
+		}
+		p, err := c.Refresh()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := map[string]string{
+			"db.host":     "fixture",
+			"db.user":     "root",
+			"db.password": "secret",
+		}
+		for key, value := range expect {
+			if got := p.Get(key); got != value {
+				t.Fatalf("%s: got %q, expect %q", key, got, value)
+			}
+		}
+		if got := names(); got != "defaults,prop,file,fixture,!env,args,dync,vault" {
+			t.Fatalf("got %v, expect %v", got, "defaults,prop,file,fixture,!env,args,dync,vault")
+		}
+	}
+
+	{
+		if err := c.RemoveLayer("fixture"); err != nil {
+			t.Fatal(err)
+		}
+		p, err := c.Refresh()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Get("db.host"); got != "prop" {
+			t.Fatalf("got %v, expect %v", got, "prop")
+		}
+	}
+
+	{
+		err := c.AddLayer("vault", fixture)
+		if expect := `layer "vault" already exists`; fmt.Sprint(err) != expect {
+			t.Fatalf("got %v, expect %v", err, expect)
+		}
+		err = c.InsertLayerAfter("fixture", "x", fixture)
+		if expect := `layer "fixture" not found`; fmt.Sprint(err) != expect {
+			t.Fatalf("got %v, expect %v", err, expect)
+		}
+		err = c.EnableLayer("fixture", true)
+		if expect := `layer "fixture" not found`; fmt.Sprint(err) != expect {
+			t.Fatalf("got %v, expect %v", err, expect)
+		}
+	}
+
+	{
+		_ = c.AddLayer("broken", funcSource(func(out *conf.Properties) error {
+			return errors.New("connection refused")
+		}))
+		_, err := c.Refresh()
+		if expect := "connection refused"; fmt.Sprint(err) != expect {
+			t.Fatalf("got %v, expect %v", err, expect)
+		}
+	}
+}
+
+func TestHTTPSource(t *testing.T) {
+
+	var (
+		mutex  sync.Mutex
+		body   = `{"db": {"host": "a"}}`
+		notMod int
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		etag := fmt.Sprintf("%q", strconv.Itoa(len(body)))
+		if r.Header.Get("If-None-Match") == etag {
+			notMod++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	setBody := func(s string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		body = s
+	}
+
+	cacheFile := t.TempDir() + "/remote.cache"
+
+	newConfiguration := func(url string) *conf.Configuration {
+		c := conf.NewConfiguration()
+		c.Env().Reset([]string{})
+		c.Args().Reset([]string{})
+		s := conf.NewHTTPSource(url)
+		s.SetInterval(10 * time.Millisecond)
+		s.SetCacheFile(cacheFile)
+		if err := c.AddLayer("remote", s); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	{
+		c := newConfiguration(server.URL + "/app")
+		events := make(chan conf.ReadOnlyProperties, 10)
+		c.Subscribe(func(old, new conf.ReadOnlyProperties, err error) {
+			events <- new
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		if err := c.Watch(ctx, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		p := <-events
+		if got := p.Get("db.host"); got != "a" {
+			t.Fatalf("got %v, expect %v", got, "a")
+		}
+		if o, _ := p.Origin("db.host"); o.Layer != "remote" || o.Source != server.URL+"/app" {
+			t.Fatalf("got %v, expect %v", o, server.URL+"/app")
+		}
+
+		setBody(`{"db": {"host": "bb"}}`)
+		select {
+		case p = <-events:
+			if got := p.Get("db.host"); got != "bb" {
+				t.Fatalf("got %v, expect %v", got, "bb")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for refresh")
+		}
+		for deadline := time.Now().Add(5 * time.Second); ; {
+			mutex.Lock()
+			n := notMod
+			mutex.Unlock()
+			if n > 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("got %v, expect %v", n, "> 0")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		cancel()
+	}
+
+	{
+		server.Close()
+		c := newConfiguration(server.URL + "/app")
+		p, err := c.Refresh()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Get("db.host"); got != "bb" {
+			t.Fatalf("got %v, expect %v", got, "bb")
+		}
+	}
+
+	{
+		_ = os.Remove(cacheFile)
+		c := newConfiguration(server.URL + "/app")
+		_, err := c.Refresh()
+		expect := fmt.Sprintf("fetch %s/app error", server.URL)
+		if err == nil || !strings.Contains(err.Error(), expect) {
+			t.Fatalf("got %v, expect %v", err, expect)
+		}
+	}
+
+	{
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte("db:\n  port: 3306\n"))
+		}))
+		defer s.Close()
+
+		c := newConfiguration(s.URL + "/app.yaml")
+		p, err := c.Refresh()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Get("db.port"); got != "3306" {
+			t.Fatalf("got %v, expect %v", got, "3306")
+		}
+
+		c = newConfiguration(s.URL + "/app")
+		_ = os.Remove(cacheFile)
+		_, err = c.Refresh()
+		expect := `unsupported content type "text/plain"`
+		if err == nil || !strings.Contains(err.Error(), expect) {
+			t.Fatalf("got %v, expect %v", err, expect)
+		}
+	}
+
+	{
+		block := make(chan struct{})
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-block:
+			case <-r.Context().Done():
+			}
+		}))
+		defer s.Close()
+		defer close(block)
+
+		src := conf.NewHTTPSource(s.URL + "/app.json")
+		src.SetClient(&http.Client{}) // no client timeout
+		src.SetTimeout(50 * time.Millisecond)
+		src.SetInterval(0)
+		src.SetInterval(-time.Second)
+
+		c := conf.NewConfiguration()
+		c.Env().Reset([]string{})
+		c.Args().Reset([]string{})
+		_ = c.AddLayer("http", src)
+
+		start := time.Now()
+		_, err := c.Refresh()
+		if err == nil || !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("got %v, expect %v", err, context.DeadlineExceeded)
+		}
+		if d := time.Since(start); d > 5*time.Second {
+			t.Fatalf("refresh took %v", d)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			src.Watch(ctx, func() {})
+		}()
+		cancel()
+		<-done
+	}
+
+	{
+		var (
+			mutex    sync.Mutex
+			status   = http.StatusOK
+			body     = `{"a": "1"}`
+			requests int
+		)
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			requests++
+			if status != http.StatusOK {
+				w.WriteHeader(status)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(body))
+		}))
+		defer s.Close()
+
+		src := conf.NewHTTPSource(s.URL + "/app")
+		src.SetInterval(10 * time.Millisecond)
+		errs := make(chan error, 100)
+		src.OnError(func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		})
+
+		c := conf.NewConfiguration()
+		c.Env().Reset([]string{})
+		c.Args().Reset([]string{})
+		_ = c.AddLayer("remote", src)
+		if _, err := c.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+		if err := src.LastError(); err != nil {
+			t.Fatal(err)
+		}
+
+		// the refresh triggered by Watch reuses the fetched content
+		refreshes := make(chan int, 10)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			src.Watch(ctx, func() {
+				mutex.Lock()
+				before := requests
+				mutex.Unlock()
+				p, err := c.Refresh()
+				if err != nil || p.Get("a") != "2" {
+					t.Errorf("got %v %v, expect %v", p, err, "a=2")
+				}
+				mutex.Lock()
+				refreshes <- requests - before
+				mutex.Unlock()
+			})
+		}()
+
+		mutex.Lock()
+		body = `{"a": "2"}`
+		mutex.Unlock()
+		select {
+		case n := <-refreshes:
+			if n != 0 {
+				t.Fatalf("got %v, expect %v", n, 0)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for refresh")
+		}
+
+		mutex.Lock()
+		status = http.StatusInternalServerError
+		mutex.Unlock()
+		expect := "status 500 Internal Server Error"
+		select {
+		case err := <-errs:
+			if !strings.Contains(err.Error(), expect) {
+				t.Fatalf("got %v, expect %v", err, expect)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for error")
+		}
+		if err := src.LastError(); err == nil || !strings.Contains(err.Error(), expect) {
+			t.Fatalf("got %v, expect %v", err, expect)
+		}
+		p, err := c.Refresh()
+		if err != nil || p.Get("a") != "2" {
+			t.Fatalf("got %v %v, expect %v", p, err, "last known good a=2")
+		}
+		cancel()
+		<-done
+	}
+}
+
+// fakeKV is an in-process KV store serving the Consul and etcd HTTP APIs
+// used by KVSource.
+type fakeKV struct {
+	mutex   sync.Mutex
+	index   uint64
+	data    map[string]string
+	changed chan struct{}
+}
+
+func newFakeKV() *fakeKV {
+	return &fakeKV{index: 1, data: map[string]string{}, changed: make(chan struct{})}
+}
+
+func (kv *fakeKV) put(key, value string) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+	kv.index++
+	kv.data[key] = value
+	close(kv.changed)
+	kv.changed = make(chan struct{})
+}
+
+// wait blocks until the index is greater than `index` or timeout.
+func (kv *fakeKV) wait(ctx context.Context, index uint64, timeout time.Duration) {
+	for {
+		kv.mutex.Lock()
+		curr, changed := kv.index, kv.changed
+		kv.mutex.Unlock()
+		if curr > index {
+			return
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return
+		case <-time.After(timeout):
+			return
+		}
+	}
+}
+
+func (kv *fakeKV) list(start, end string) ([]string, uint64) {
+	kv.mutex.Lock()
+	defer kv.mutex.Unlock()
+	var keys []string
+	for k := range kv.data {
+		if k >= start && (end == "\x00" || k < end) {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	return keys, kv.index
+}
+
+func (kv *fakeKV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/v1/kv/"):
+		if index, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64); index > 0 {
+			wait, _ := time.ParseDuration(r.URL.Query().Get("wait"))
+			kv.wait(r.Context(), index, wait)
+		}
+		prefix := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		keys, index := kv.list(prefix, prefix+"\xff")
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+		if len(keys) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var entries []map[string]interface{}
+		for _, k := range keys {
+			kv.mutex.Lock()
+			entries = append(entries, map[string]interface{}{"Key": k, "Value": []byte(kv.data[k])})
+			kv.mutex.Unlock()
+		}
+		_ = json.NewEncoder(w).Encode(entries)
+
+	case r.URL.Path == "/v3/kv/range":
+		var req struct {
+			Key      []byte `json:"key"`
+			RangeEnd []byte `json:"range_end"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		keys, index := kv.list(string(req.Key), string(req.RangeEnd))
+		var kvs []map[string]interface{}
+		for _, k := range keys {
+			kv.mutex.Lock()
+			kvs = append(kvs, map[string]interface{}{"key": []byte(k), "value": []byte(kv.data[k])})
+			kv.mutex.Unlock()
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"header": map[string]string{"revision": strconv.FormatUint(index, 10)},
+			"kvs":    kvs,
+		})
+
+	case r.URL.Path == "/v3/watch":
+		var req struct {
+			CreateRequest struct {
+				StartRevision uint64 `json:"start_revision,string"`
+			} `json:"create_request"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"result": map[string]interface{}{"created": true}})
+		w.(http.Flusher).Flush()
+		kv.wait(r.Context(), req.CreateRequest.StartRevision-1, time.Hour)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"result": map[string]interface{}{"events": []map[string]string{{"type": "PUT"}}},
+		})
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestKVSource(t *testing.T) {
+
+	kv := newFakeKV()
+	kv.put("app/db/host", "a")
+	kv.put("app/servers/0/host", "h0")
+	kv.put("app/servers/1/host", "h1")
+	kv.put("application/x", "1")
+
+	server := httptest.NewServer(kv)
+	defer server.Close()
+
+	sources := map[string]*conf.KVSource{
+		"consul": conf.NewConsulSource(server.URL, "app"),
+		"etcd":   conf.NewEtcdSource(server.URL, "/app/"),
+	}
+
+	for name, s := range sources {
+		s.SetIndexSegments(true)
+		c := conf.NewConfiguration()
+		c.Env().Reset([]string{})
+		c.Args().Reset([]string{})
+		if err := c.AddLayer(name, s); err != nil {
+			t.Fatal(err)
+		}
+
+		events := make(chan conf.ReadOnlyProperties, 10)
+		c.Subscribe(func(old, new conf.ReadOnlyProperties, err error) {
+			events <- new
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		if err := c.Watch(ctx, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+
+		p := <-events
+		expect := []string{"db.host", "servers[0].host", "servers[1].host"}
+		if got := p.Keys(); !reflect.DeepEqual(got, expect) {
+			t.Fatalf("%s: got %v, expect %v", name, got, expect)
+		}
+		if o, _ := p.Origin("servers[1].host"); o.Layer != name || o.Source != "app/servers/1/host" {
+			t.Fatalf("%s: got %v, expect %v", name, o, "app/servers/1/host")
+		}
+
+		kv.put("app/db/host", "b-"+name)
+		select {
+		case p = <-events:
+			if got := p.Get("db.host"); got != "b-"+name {
+				t.Fatalf("%s: got %v, expect %v", name, got, "b-"+name)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: timeout waiting for refresh", name)
+		}
+		cancel()
+	}
+
+	{
+		kv := newFakeKV()
+		kv.put("app/codes/404", "missing")
+		server := httptest.NewServer(kv)
+		defer server.Close()
+
+		file := t.TempDir() + "/app.yaml"
+		if err := os.WriteFile(file, []byte("codes:\n  \"404\": not found\n  \"500\": error"), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		c := conf.NewConfiguration()
+		c.File().Add(file)
+		c.Env().Reset([]string{})
+		c.Args().Reset([]string{})
+		_ = c.AddLayer("consul", conf.NewConsulSource(server.URL, "app"))
+		p, err := c.Refresh()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Get("codes.404"); got != "missing" {
+			t.Fatalf("got %v, expect %v", got, "missing")
+		}
+	}
+
+	{
+		s := conf.NewConsulSource("http://127.0.0.1:0", "app")
+		c := conf.NewConfiguration()
+		c.Env().Reset([]string{})
+		c.Args().Reset([]string{})
+		_ = c.AddLayer("consul", s)
+		_, err := c.Refresh()
+		expect := "list http://127.0.0.1:0/v1/kv/app/?recurse=true error"
+		if err == nil || !strings.Contains(err.Error(), expect) {
+			t.Fatalf("got %v, expect %v", err, expect)
+		}
+	}
+
+	{
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		s := conf.NewConsulSource(server.URL, "app")
+		errs := make(chan error, 10)
+		s.OnError(func(err error) { errs <- err })
+		if err := s.CopyTo(conf.New()); err == nil {
+			t.Fatal("expect an error")
+		}
+		if err := s.LastError(); err == nil || err != <-errs {
+			t.Fatalf("got %v, expect %v", err, "the reported error")
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			s.Watch(ctx, func() {})
+		}()
+		select {
+		case err := <-errs:
+			expect := "500 Internal Server Error"
+			if !strings.Contains(err.Error(), expect) {
+				t.Fatalf("got %v, expect %v", err, expect)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for error")
+		}
+		cancel()
+		<-done
+	}
+}
+
+type secretMap map[string]string
+
+func (m secretMap) ResolveSecret(name string) (string, error) {
+	if s, ok := m[name]; ok {
+		return s, nil
+	}
+	return "", fmt.Errorf("secret %q %w", name, conf.ErrNotExist)
+}
+
+func TestSecrets(t *testing.T) {
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+	c, err := conf.NewAESGCM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypt := func(s string) string {
+		v, err := c.Encrypt(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	p := conf.New()
+	_ = p.Set("db.password", encrypt("s3cr3t-pw"))
+	_ = p.Set("db.port", encrypt("s3cr3t-port"))
+	_ = p.Set("db.dsn", "root:${db.password}@localhost")
+
+	{
+		_, err = p.Resolve("${db.password}")
+		expect := "decrypt ENC() value error, no decryptor"
+		if err == nil || !strings.Contains(err.Error(), expect) {
+			t.Fatalf("got %v, expect %v", err, expect)
+		}
+	}
+
+	conf.SetDecryptor(c)
+	defer conf.SetDecryptor(nil)
+
+	{
+		var s struct {
+			Password string `value:"${db.password}"`
+			DSN      string `value:"${db.dsn}"`
+		}
+		if err = p.Bind(&s); err != nil {
+			t.Fatal(err)
+		}
+		if s.Password != "s3cr3t-pw" || s.DSN != "root:s3cr3t-pw@localhost" {
+			t.Fatalf("got %v, expect %v", s, "decrypted values")
+		}
+		var token string
+		if err = p.Bind(&token, conf.Tag("${x:="+encrypt("tk")+"}")); err != nil || token != "tk" {
+			t.Fatalf("got %v %v, expect %v", token, err, "tk")
+		}
+	}
+
+	{
+		var port int
+		err = p.Bind(&port, conf.Key("db.port"))
+		if err == nil || strings.Contains(err.Error(), "s3cr3t-port") || !strings.Contains(err.Error(), conf.Redacted) {
+			t.Fatalf("got %v, expect the secret redacted", err)
+		}
+		if !errors.Is(err, strconv.ErrSyntax) {
+			t.Fatalf("got %v, expect %v", err, strconv.ErrSyntax)
+		}
+	}
+
+	{
+		old := conf.New()
+		_ = old.Set("db.password", "plain")
+		changes := conf.Diff(old, p)
+		for _, change := range changes {
+			if change.Key == "db.password" && (change.Old != conf.Redacted || change.New != conf.Redacted) {
+				t.Fatalf("got %v, expect %v", change, "redacted by key")
+			}
+		}
+		if got := p.Redact("pw=s3cr3t-pw"); got != "pw=******" {
+			t.Fatalf("got %v, expect %v", got, "pw=******")
+		}
+		if got := conf.New().Redact("pw=s3cr3t-pw"); got != "pw=s3cr3t-pw" {
+			t.Fatalf("got %v, expect %v", got, "pw=s3cr3t-pw")
+		}
+	}
+
+	{
+		p := conf.New()
+		_ = p.Set("pin", encrypt("1"))
+		_ = p.Set("port", "10")
+		var pin bool
+		err = p.Bind(&pin, conf.Key("pin"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n uint8
+		_ = p.Set("pin", encrypt("a"))
+		err = p.Bind(&n, conf.Key("pin"))
+		if err == nil || !strings.Contains(err.Error(), `"******"`) || strings.Contains(err.Error(), `"a"`) {
+			t.Fatalf("got %v, expect the short secret redacted", err)
+		}
+		if !regexp.MustCompile(`bind\.go:\d+:`).MatchString(err.Error()) {
+			t.Fatalf("got %v, expect the file lines unchanged", err)
+		}
+		if got := p.Redact(`port="10" a=1`); got != `port="10" a=1` {
+			t.Fatalf("got %v, expect %v", got, `port="10" a=1`)
+		}
+
+		old := conf.New()
+		_ = old.Set("port", "100")
+		for _, change := range conf.Diff(old, p) {
+			if change.Key == "port" && change.New != "10" {
+				t.Fatalf("got %v, expect %v", change.New, "10")
+			}
+		}
+	}
+
+	{
+		other, _ := conf.NewAESGCM([]byte("fedcba9876543210"))
+		_ = p.Set("db.password", encrypt("x"))
+		conf.SetDecryptor(other)
+		_, err = p.Resolve("${db.password}")
+		if expect := "decrypt ENC() value error"; err == nil || !strings.Contains(err.Error(), expect) {
+			t.Fatalf("got %v, expect %v", err, expect)
+		}
+		conf.SetDecryptor(c)
+	}
+
+	{
+		conf.SetSecretResolver(secretMap{"prod/db": "token-1"})
+		defer conf.SetSecretResolver(nil)
+
+		_ = p.Set("env", "prod")
+		s, err := p.Resolve("${secret:${env}/db}|${secret:prod/missing:=none}")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s != "token-1|none" {
+			t.Fatalf("got %v, expect %v", s, "token-1|none")
+		}
+
+		_, err = p.Resolve("${secret:prod/missing}")
+		if !errors.Is(err, conf.ErrNotExist) {
+			t.Fatalf("got %v, expect %v", err, conf.ErrNotExist)
+		}
+	}
+
+	{
+		k := base64.StdEncoding.EncodeToString(key)
+		t.Setenv("TEST_CONF_SECRET_KEY", k)
+		fromEnv, err := conf.AESGCMFromEnv("TEST_CONF_SECRET_KEY")
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := t.TempDir() + "/key"
+		if err = os.WriteFile(file, []byte(k+"\n"), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		fromFile, err := conf.AESGCMFromFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v := encrypt("hello")
+		for _, d := range []*conf.AESGCM{fromEnv, fromFile} {
+			s, err := d.Decrypt(v[4 : len(v)-1])
+			if err != nil || s != "hello" {
+				t.Fatalf("got %v %v, expect %v", s, err, "hello")
+			}
+		}
+		if _, err = conf.AESGCMFromEnv("TEST_CONF_NO_SUCH_KEY"); err == nil {
+			t.Fatal("expect error")
+		}
+	}
+}
+
+func TestResolverFunc(t *testing.T) {
+
+	dir := t.TempDir()
+	if err := os.WriteFile(dir+"/token", []byte("tk-1\n"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_CONF_HOME", "/home/conf")
+
+	conf.RegisterResolverFunc("join", func(args ...string) (string, error) {
+		return strings.Join(args, "+"), nil
+	})
+
+	p := conf.New()
+	_ = p.Set("name", "go-conf")
+	_ = p.Set("dir", dir)
+	_ = p.Set("home", "${env:TEST_CONF_HOME}/app")
+	_ = p.Set("uuid", "not-a-function")
+
+	{
+		testcases := map[string]string{
+			"${env:TEST_CONF_HOME}":                "/home/conf",
+			"${env:TEST_CONF_MISSING:=/tmp}":       "/tmp",
+			"${file:${dir}/token}":                 "tk-1",
+			"${base64:Z28tY29uZg==}":               "go-conf",
+			"${upper:${name}}":                     "GO-CONF",
+			"${upper:${missing:=a:=b}}":            "A:=B",
+			"${lower:ABC}-${upper:x}":              "abc-X",
+			"${join(a, ${name}, ${x:=1,2})}":       "a+go-conf+1,2",
+			"${random.int(7,8)}":                   "7",
+			"${home}":                              "/home/conf/app",
+			"${uuid}":                              "not-a-function",
+			"${file:${dir}/missing:=${name}}":      "go-conf",
+			"${env:TEST_CONF_MISSING:=${env:X:=}}": "",
+		}
+		for s, expect := range testcases {
+			got, err := p.Resolve(s)
+			if err != nil {
+				t.Fatalf("%s: %v", s, err)
+			}
+			if got != expect {
+				t.Fatalf("%s: got %q, expect %q", s, got, expect)
+			}
+		}
+	}
+
+	{
+		for i := 0; i < 10; i++ {
+			s, err := p.Resolve("${random.int(1,10)}")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n, _ := strconv.Atoi(s); n < 1 || n >= 10 {
+				t.Fatalf("got %v, expect %v", n, "[1,10)")
+			}
+		}
+		s, err := conf.New().Resolve("${uuid}")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(s) != 36 || s[14] != '4' {
+			t.Fatalf("got %v, expect a v4 uuid", s)
+		}
+	}
+
+	{
+		testcases := map[string]string{
+			"${env:TEST_CONF_MISSING}": `environment variable "TEST_CONF_MISSING" not exist`,
+			"${random.int(3,1)}":       "random.int range [3, 1) is empty",
+			"${base64:!}":              "illegal base64 data",
+		}
+		for s, expect := range testcases {
+			_, err := p.Resolve(s)
+			if err == nil || !strings.Contains(err.Error(), expect) {
+				t.Fatalf("%s: got %v, expect %v", s, err, expect)
+			}
+		}
+	}
+
+	{
+		var s struct {
+			Home  string `value:"${env:TEST_CONF_HOME}"`
+			Token string `value:"${file:${dir}/token}"`
+			Port  int    `value:"${port:=${random.int(8000,8001)}}"`
+		}
+		if err := p.Bind(&s, conf.Key("app")); err != nil {
+			t.Fatal(err)
+		}
+		if s.Home != "/home/conf" || s.Token != "tk-1" || s.Port != 8000 {
+			t.Fatalf("got %v, expect %v", s, "resolved values")
+		}
+	}
+
+	{
+		c := conf.NewConfiguration()
+		c.Env().Reset([]string{})
+		c.Args().Reset([]string{})
+		c.File().Require("${env:TEST_CONF_DIR:=" + dir + "}/app.properties")
+		if err := os.WriteFile(dir+"/app.properties", []byte("a=1"), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		p, err := c.Refresh()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Get("a"); got != "1" {
+			t.Fatalf("got %v, expect %v", got, "1")
+		}
+	}
+
+	{
+		p := conf.New()
+		var s struct {
+			File  string `value:"${file:=app.log}"`
+			Env   string `value:"${env:=x}"`
+			Upper string `value:"${upper:=a}"`
+		}
+		if err := p.Bind(&s); err != nil {
+			t.Fatal(err)
+		}
+		if s.File != "app.log" || s.Env != "x" || s.Upper != "a" {
+			t.Fatalf("got %v, expect %v", s, "default values")
+		}
+		for _, key := range []string{"${file}", "${env}", "${lower}"} {
+			if _, err := p.Resolve(key); !errors.Is(err, conf.ErrNotExist) {
+				t.Fatalf("%s: got %v, expect %v", key, err, conf.ErrNotExist)
+			}
+		}
+	}
+}
+
+func TestCircularReference(t *testing.T) {
+
+	p := conf.New()
+	_ = p.Set("a", "${b}")
+	_ = p.Set("b", "x-${a}")
+	_ = p.Set("c", "${a}")
+	_ = p.Set("self", "${self:=1}")
+	_ = p.Set("f", "${upper:${f}}")
+	_ = p.Set("d", "${b1}${b1}")
+	_ = p.Set("b1", "${b2}")
+	_ = p.Set("b2", "ok")
+
+	{
+		testcases := map[string]string{
+			"${a}":    "circular reference a -> b -> a",
+			"${c}":    "circular reference c -> a -> b -> a",
+			"${self}": "circular reference self -> self",
+			"${f}":    "circular reference f -> f",
+		}
+		for s, expect := range testcases {
+			_, err := p.Resolve(s)
+			if !errors.Is(err, conf.ErrCircularReference) || !strings.HasSuffix(err.Error(), expect) {
+				t.Fatalf("%s: got %v, expect %v", s, err, expect)
+			}
+		}
+	}
+
+	{
+		var s struct {
+			C string `value:"${c}"`
+		}
+		err := p.Bind(&s)
+		if !errors.Is(err, conf.ErrCircularReference) {
+			t.Fatalf("got %v, expect %v", err, conf.ErrCircularReference)
+		}
+	}
+
+	{
+		s, err := p.Resolve("${d}-${b1}")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s != "okok-ok" {
+			t.Fatalf("got %v, expect %v", s, "okok-ok")
+		}
+	}
+
+	{
+		for i := 0; i < 5; i++ {
+			_ = p.Set(fmt.Sprintf("n%d", i), fmt.Sprintf("${n%d}", i+1))
+		}
+		_ = p.Set("n5", "end")
+		conf.SetMaxResolveDepth(3)
+		defer conf.SetMaxResolveDepth(64)
+		_, err := p.Resolve("${n0}")
+		expect := "reference depth exceeds 3, n0 -> n1 -> n2 -> n3"
+		if err == nil || !strings.HasSuffix(err.Error(), expect) {
+			t.Fatalf("got %v, expect %v", err, expect)
+		}
+		conf.SetMaxResolveDepth(6)
+		if s, err := p.Resolve("${n0}"); err != nil || s != "end" {
+			t.Fatalf("got %v %v, expect %v", s, err, "end")
+		}
+	}
+
+	for _, depth := range []int{0, -1} {
+		func() {
+			defer func() {
+				r := recover()
+				expect := "max resolve depth should be positive"
+				if fmt.Sprint(r) != expect {
+					t.Fatalf("got %v, expect %v", r, expect)
+				}
+			}()
+			conf.SetMaxResolveDepth(depth)
+		}()
+	}
+}
+
+type namingPool struct {
+	_            struct{} `naming:"kebab"`
+	MaxIdleConns int
+	HTTPTimeout  string
+}
+
+func TestNaming(t *testing.T) {
+
+	p := conf.New()
+	_ = p.Set("db.maxidleconns", "1")
+	_ = p.Set("db.max_idle_conns", "2")
+	_ = p.Set("db.maxIdleConns", "3")
+	_ = p.Set("db.MaxIdleConns", "4")
+	_ = p.Set("db.pool.max-idle-conns", "5")
+	_ = p.Set("db.pool.http-timeout", "5s")
+	_ = p.Set("db.Pool.max-idle-conns", "5")
+	_ = p.Set("db.Pool.http-timeout", "5s")
+	_ = p.Set("db.user_name", "root")
+
+	type DB struct {
+		MaxIdleConns int
+		Pool         namingPool
+	}
+
+	testcases := []struct {
+		naming string
+		expect int
+	}{
+		{"", 1},
+		{"lower", 1},
+		{"snake", 2},
+		{"camel", 3},
+		{"exact", 4},
+	}
+	for _, c := range testcases {
+		var v DB
+		if err := p.Bind(&v, conf.Key("db"), conf.Naming(c.naming)); err != nil {
+			t.Fatalf("%s: %v", c.naming, err)
+		}
+		if v.MaxIdleConns != c.expect {
+			t.Fatalf("%s: got %d, expect %d", c.naming, v.MaxIdleConns, c.expect)
+		}
+		if v.Pool.MaxIdleConns != 5 || v.Pool.HTTPTimeout != "5s" {
+			t.Fatalf("%s: got %v, expect %v", c.naming, v.Pool, "kebab keys")
+		}
+	}
+
+	{
+		var v struct {
+			MaxIdleConns int
+			UserName     string
+		}
+		if err := p.Bind(&v, conf.Key("db"), conf.Naming("kebab,relaxed")); err != nil {
+			t.Fatal(err)
+		}
+		if v.MaxIdleConns != 1 || v.UserName != "root" {
+			t.Fatalf("got %v, expect %v", v, "relaxed keys")
+		}
+	}
+
+	{
+		var v struct {
+			Sub struct {
+				MaxIdleConns int
+			}
+		}
+		_ = p.Set("db.sub.max_idle_conns", "9")
+		if err := p.Bind(&v, conf.Key("db"), conf.Naming("snake")); err != nil {
+			t.Fatal(err)
+		}
+		if v.Sub.MaxIdleConns != 9 {
+			t.Fatalf("got %v, expect %v", v.Sub.MaxIdleConns, 9)
+		}
+	}
+
+	{
+		var v DB
+		err := p.Bind(&v, conf.Key("db"), conf.Naming("unknown"))
+		if err == nil || !strings.Contains(err.Error(), `unknown naming strategy "unknown"`) {
+			t.Fatalf("got %v, expect %v", err, "unknown naming strategy")
+		}
+	}
+
+	{
+		conf.RegisterNaming("dotted", func(name string) string {
+			return strings.ToLower(strings.Join(strings.SplitAfter(name, "Idle"), "."))
+		})
+		var v struct {
+			MaxIdleConns int
+		}
+		_ = p.Set("x.maxidle.conns", "6")
+		if err := p.Bind(&v, conf.Key("x"), conf.Naming("dotted")); err != nil {
+			t.Fatal(err)
+		}
+		if v.MaxIdleConns != 6 {
+			t.Fatalf("got %v, expect %v", v.MaxIdleConns, 6)
+		}
+	}
+
+	{
+		v := struct {
+			MaxIdleConns int
+			Pool         namingPool
+		}{MaxIdleConns: 7, Pool: namingPool{MaxIdleConns: 8}}
+		s, err := conf.FromStruct(v, conf.Key("db"), conf.Naming("snake"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := s.Get("db.max_idle_conns"); got != "7" {
+			t.Fatalf("got %v, expect %v", got, "7")
+		}
+		if got := s.Get("db.pool.max-idle-conns"); got != "8" {
+			t.Fatalf("got %v, expect %v", got, "8")
+		}
+	}
+}
+
+func TestCanonicalKeys(t *testing.T) {
+
+	{
+		p := conf.New()
+		_ = p.Set("Server.Port", "8080")
+		_ = p.Set("server.port", "9090")
+		if got := p.Keys(); !reflect.DeepEqual(got, []string{"Server.Port", "server.port"}) {
+			t.Fatalf("got %v, expect %v", got, "case-sensitive keys")
+		}
+		if err := p.EnableCanonicalKeys(); err != nil {
+			t.Fatal(err)
+		}
+		if !p.CanonicalKeys() {
+			t.Fatal("should be canonical")
+		}
+		if got := p.Keys(); !reflect.DeepEqual(got, []string{"server.port"}) {
+			t.Fatalf("got %v, expect %v", got, []string{"server.port"})
+		}
+		if got := p.Get("SERVER.PORT"); got != "9090" {
+			t.Fatalf("got %v, expect %v", got, "9090")
+		}
+		expect := []conf.Collision{{Key: "server.port", Old: "Server.Port", New: "server.port"}}
+		if got := p.Collisions(); !reflect.DeepEqual(got, expect) {
+			t.Fatalf("got %v, expect %v", got, expect)
+		}
+	}
+
+	{
+		p := conf.New()
+		_ = p.EnableCanonicalKeys()
+		_ = p.Set("db.max-idle-conns", "1")
+		_ = p.Set("db.Max_Idle_Conns", "2")
+		_ = p.Set("db.hosts", []string{"a", "b"})
+		for _, key := range []string{"db.maxidleconns", "DB.MAX_IDLE_CONNS", "db.max-idle-conns"} {
+			if !p.Has(key) || p.Get(key) != "2" {
+				t.Fatalf("%s: got %v, expect %v", key, p.Get(key), "2")
+			}
+		}
+		var v struct {
+			MaxIdleConns int      `value:"${max-idle-conns}"`
+			Hosts        []string `value:"${HOSTS}"`
+		}
+		if err := p.Bind(&v, conf.Key("Db")); err != nil {
+			t.Fatal(err)
+		}
+		if v.MaxIdleConns != 2 || !reflect.DeepEqual(v.Hosts, []string{"a", "b"}) {
+			t.Fatalf("got %v, expect %v", v, "canonical lookups")
+		}
+		if got := len(p.Copy().Collisions()); got != 1 {
+			t.Fatalf("got %v, expect %v", got, 1)
+		}
+		if _, err := p.Resolve("${Db.Hosts[1]}"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	{
+		dir := t.TempDir()
+		file := dir + "/app.yaml"
+		if err := os.WriteFile(file, []byte("Server:\n  Port: 8080"), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+
+		c := conf.NewConfiguration()
+		c.File().Add(file)
+		c.Env().Reset([]string{"GS_SERVER_PORT=9090"})
+		c.Args().Reset([]string{})
+
+		p, err := c.Refresh()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Get("Server.Port"); got != "8080" {
+			t.Fatalf("got %v, expect %v", got, "8080")
+		}
+
+		c.SetCanonicalKeys(true)
+		if p, err = c.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Get("Server.Port"); got != "9090" {
+			t.Fatalf("got %v, expect %v", got, "9090")
+		}
+		if o, _ := p.Origin("server.port"); o.Layer != "env" {
+			t.Fatalf("got %v, expect %v", o, "env")
+		}
+		expect := []conf.Collision{{Key: "server.port", Old: "Server.Port", New: "server.port"}}
+		if got := p.Collisions(); !reflect.DeepEqual(got, expect) {
+			t.Fatalf("got %v, expect %v", got, expect)
+		}
+	}
+}
+
+func TestEnvMapping(t *testing.T) {
+
+	testcases := map[string]string{
+		"SERVER_PORT":     "server.port",
+		"SERVER_PORTS_1":  "server.ports[1]",
+		"DB_MAX__CONNS":   "db.max_conns",
+		"DB_HOSTS_0_NAME": "db.hosts[0].name",
+		"A_1_2":           "a[1][2]",
+		"_":               "",
+		"__":              "_",
+		"0_A":             "0.a",
+		"TRAILING_":       "trailing",
+	}
+	for name, expect := range testcases {
+		if got := conf.EnvKey(name, true); got != expect {
+			t.Fatalf("%s: got %q, expect %q", name, got, expect)
+		}
+	}
+	if got := conf.EnvKey("CODES_404_MSG", false); got != "codes.404.msg" {
+		t.Fatalf("got %q, expect %q", got, "codes.404.msg")
+	}
+
+	{
+		file := t.TempDir() + "/app.yaml"
+		if err := os.WriteFile(file, []byte("codes:\n  \"404\": not found"), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		c := conf.NewConfiguration()
+		c.File().Add(file)
+		c.Env().Reset([]string{"GS_CODES_404=missing", "GS_CODES_500=error"})
+		c.Args().Reset([]string{})
+		p, err := c.Refresh()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var codes map[string]string
+		if err = p.Bind(&codes, conf.Key("codes")); err != nil {
+			t.Fatal(err)
+		}
+		if expect := map[string]string{"404": "missing", "500": "error"}; !reflect.DeepEqual(codes, expect) {
+			t.Fatalf("got %v, expect %v", codes, expect)
+		}
+	}
+
+	{
+		c := conf.NewConfiguration()
+		c.Env().Reset([]string{
+			"_=/usr/bin/env",
+			"GS_SERVER_PORTS_0=8080",
+			"GS_SERVER_PORTS_1=8081",
+			"GS_DB_MAX__CONNS=10",
+			"GS_DB_HOSTS=[\"a\",\"b\"]",
+			"GS_DB_OPTS={\"ssl\":true}",
+			"GS_DB_BAD=[x",
+		})
+		c.Env().SetIndexSegments(true)
+		c.Args().Reset([]string{})
+
+		p, err := c.Refresh()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v struct {
+			Ports    []int  `value:"${server.ports}"`
+			MaxConns int    `value:"${db.max_conns}"`
+			Hosts    string `value:"${db.hosts}"`
+		}
+		if err = p.Bind(&v); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(v.Ports, []int{8080, 8081}) || v.MaxConns != 10 || v.Hosts != `["a","b"]` {
+			t.Fatalf("got %v, expect %v", v, "mapped keys")
+		}
+
+		c.Env().SetJSONValues(true)
+		if p, err = c.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+		expect := map[string]string{
+			"db.hosts[0]": "a",
+			"db.hosts[1]": "b",
+			"db.opts.ssl": "true",
+			"db.bad":      "[x",
+		}
+		for key, val := range expect {
+			if got := p.Get(key); got != val {
+				t.Fatalf("%s: got %q, expect %q", key, got, val)
+			}
+		}
+		if o, _ := p.Origin("db.hosts[1]"); o.Source != "GS_DB_HOSTS" {
+			t.Fatalf("got %v, expect %v", o.Source, "GS_DB_HOSTS")
+		}
+
+		c.Env().SetKeyMapper(func(name string) string {
+			if !strings.HasPrefix(name, "DB_") {
+				return ""
+			}
+			return "database." + strings.ToLower(strings.TrimPrefix(name, "DB_"))
+		})
+		if p, err = c.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+		if p.Has("server") || p.Get("database.max__conns") != "10" {
+			t.Fatalf("got %v, expect %v", p.Keys(), "custom keys")
+		}
+	}
+}
+
+// tickSource is a Watcher that changes on every tick.
+type tickSource struct{}
+
+func (tickSource) CopyTo(out *conf.Properties) error {
+	return nil
+}
+
+func (tickSource) Watch(ctx context.Context, changed func()) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Millisecond):
+			changed()
+		}
+	}
+}
+
+// TestConcurrentMutators is meaningful with -race, the mutators shouldn't
+// race with the refreshes of Watch.
+func TestConcurrentMutators(t *testing.T) {
+
+	c := conf.NewConfiguration()
+	c.Env().Reset([]string{})
+	c.Args().Reset([]string{})
+	_ = c.AddLayer("tick", tickSource{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := c.Watch(ctx, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 200; i++ {
+		_ = c.SetProperty("key", i)
+		c.SetWorkDir(t.TempDir())
+		c.SetCanonicalKeys(i%2 == 0)
+		c.File().Add("not-exist.properties")
+		c.File().Clear()
+		c.Dync().Add("not-exist.yaml")
+		c.Env().Reset([]string{fmt.Sprintf("GS_ENV=%d", i)})
+		c.Env().SetPrefix("GS_")
+		c.Args().Reset([]string{"-D", fmt.Sprintf("args=%d", i)})
+		time.Sleep(100 * time.Microsecond)
+	}
+	if _, err := c.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -24,6 +24,7 @@ import (
 
 // CommandArgs command-line parameters
 type CommandArgs struct {
+	guard
 	option  string
 	cmdArgs []string
 }
@@ -36,15 +37,17 @@ func NewCommandArgs() *CommandArgs {
 }
 
 func (c *CommandArgs) Reset(args []string) {
+	defer c.lock()()
 	c.cmdArgs = args
 }
 
 func (c *CommandArgs) SetOption(option string) {
+	defer c.lock()()
 	c.option = option
 }
 
-// copyTo loads parameters passed in the form of -D key[=value/true].
-func (c *CommandArgs) copyTo(out *conf.Properties) error {
+// CopyTo loads parameters passed in the form of -D key[=value/true].
+func (c *CommandArgs) CopyTo(out *conf.Properties) error {
 	n := len(c.cmdArgs)
 	for i := 0; i < n; i++ {
 		s := c.cmdArgs[i]
@@ -57,7 +60,7 @@ func (c *CommandArgs) copyTo(out *conf.Properties) error {
 			if len(ss) == 1 {
 				ss = append(ss, "true")
 			}
-			if err := out.Set(ss[0], ss[1]); err != nil {
+			if err := out.WithSource(s+" "+next).Set(ss[0], ss[1]); err != nil {
 				return err
 			}
 		}
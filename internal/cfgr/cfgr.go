@@ -15,8 +15,14 @@
 package cfgr
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/lvan100/go-conf/internal/conf"
 )
@@ -36,47 +42,114 @@ type ReadOnlyProperties interface {
 	// Get returns key's value, using Def to return a default value.
 	Get(key string, opts ...conf.GetOption) string
 
+	// Tree returns the nested structure of the properties.
+	Tree() map[string]interface{}
+
+	// Marshal serializes the properties, ext is the file name extension.
+	Marshal(ext string) ([]byte, error)
+
 	// Resolve resolves string that contains references.
 	Resolve(s string) (string, error)
 
 	// Bind binds properties into a value.
 	Bind(i interface{}, args ...conf.BindArg) error
+
+	// Origin returns where the key's current value comes from.
+	Origin(key string) (conf.Origin, bool)
+
+	// Origins returns all the origins that set the key, the earlier ones
+	// are overridden by the later ones.
+	Origins(key string) []conf.Origin
+
+	// Redact replaces the secrets decrypted or resolved in the string.
+	Redact(s string) string
+
+	// Collisions returns the different spellings of the keys that merged,
+	// it's always empty when the keys aren't canonical.
+	Collisions() []conf.Collision
 }
 
 /******************************* Configuration *******************************/
 
-// Configuration is a layered configuration manager.
+// Configuration is a layered configuration manager, it has five layers by
+// default, and more layers can be added in any order of precedence.
 // prop - used to store the properties set by code,
 // file - used to load properties from local static files,
 // env - used to load properties from environment variables,
 // args - used to load properties from command line arguments,
 // dync - used to load properties from remote dynamic sources.
+// The mutators of Configuration and its built-in layers hold the refresh
+// mutex, so they are safe during Watch, but can't be called by the
+// listeners which are notified while the mutex is held.
 type Configuration struct {
-	prop *conf.Properties
-	file *PropertySources
-	env  *Environment
-	args *CommandArgs
-	dync *PropertySources
+	prop   *conf.Properties
+	file   *PropertySources
+	env    *Environment
+	args   *CommandArgs
+	dync   *PropertySources
+	layers []*layer
+
+	canonicalKeys bool
+
+	refreshMutex sync.Mutex // serializes refreshes and notifications
+	currentMutex sync.RWMutex
+	current      *conf.Properties
+	profiles     []string // active profiles of the current snapshot
+	listeners    []RefreshListener
+	keyListeners []keyListener
+	refreshables []refreshable
+}
+
+type refreshable struct {
+	value conf.Refreshable
+	param conf.BindParam
 }
 
 func New() *Configuration {
-	return &Configuration{
+	c := &Configuration{
 		prop: conf.New(),
 		file: NewPropertySources(),
 		env:  NewEnvironment(),
 		args: NewCommandArgs(),
 		dync: NewPropertySources(),
 	}
+	c.file.guard = guard{&c.refreshMutex}
+	c.env.guard = guard{&c.refreshMutex}
+	c.args.guard = guard{&c.refreshMutex}
+	c.dync.guard = guard{&c.refreshMutex}
+	c.layers = []*layer{
+		{name: "prop", source: c.prop},
+		{name: "file", source: c.file},
+		{name: "env", source: c.env},
+		{name: "args", source: c.args},
+		{name: "dync", source: c.dync},
+	}
+	return c
 }
 
 // SetWorkDir sets the working directory.
 func (c *Configuration) SetWorkDir(dir string) {
+	c.refreshMutex.Lock()
+	defer c.refreshMutex.Unlock()
 	c.file.workDir = dir
 	c.dync.workDir = dir
 }
 
+// SetCanonicalKeys makes the merged properties use the canonical keys, see
+// conf.Properties.EnableCanonicalKeys, so that `Server.Port` in the files and
+// `GS_SERVER_PORT` in the environment are the same key. It takes effect from
+// the next refresh, and the collisions are returned by Collisions of the
+// refreshed properties.
+func (c *Configuration) SetCanonicalKeys(enable bool) {
+	c.refreshMutex.Lock()
+	defer c.refreshMutex.Unlock()
+	c.canonicalKeys = enable
+}
+
 // SetProperty sets a property that will be stored in the prop layer.
 func (c *Configuration) SetProperty(key string, val interface{}) error {
+	c.refreshMutex.Lock()
+	defer c.refreshMutex.Unlock()
 	return c.prop.Set(key, val)
 }
 
@@ -96,74 +169,325 @@ func (c *Configuration) Dync() *PropertySources {
 	return c.dync
 }
 
-func merge(p *conf.Properties, sources ...interface {
-	copyTo(out *conf.Properties) error
-}) (*conf.Properties, error) {
-	for _, s := range sources {
-		if err := s.copyTo(p); err != nil {
+// Refresh merges all layers and returned as a read-only properties, and
+// then rebinds all the dynamic values bound by Bind. The result becomes the
+// current snapshot only when all of these succeeded, otherwise the current
+// snapshot and all the dynamic values remain unchanged. The subscribers are
+// notified whether it succeeded or not.
+func (c *Configuration) Refresh() (ReadOnlyProperties, error) {
+	c.refreshMutex.Lock()
+	defer c.refreshMutex.Unlock()
+
+	c.currentMutex.RLock()
+	old := c.current
+	c.currentMutex.RUnlock()
+
+	p, profiles, err := c.refresh()
+	if err != nil {
+		c.notify(old, nil, err)
+		return nil, err
+	}
+
+	c.currentMutex.Lock()
+	c.current = p
+	c.profiles = profiles
+	c.currentMutex.Unlock()
+
+	c.notify(old, p, nil)
+	return p, nil
+}
+
+// refresh merges all layers twice when there are active profiles, the first
+// time without the profiles to decide the active profiles, and the second
+// time with the profile-specific files and documents.
+func (c *Configuration) refresh() (*conf.Properties, []string, error) {
+	p, err := c.merge(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	profiles, err := activeProfiles(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(profiles) > 0 {
+		if p, err = c.merge(profiles); err != nil {
+			return nil, nil, err
+		}
+	}
+	var (
+		errs    []error
+		commits []func()
+	)
+	for _, r := range c.refreshables {
+		commit, err := r.value.OnRefresh(p, r.param)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		commits = append(commits, commit)
+	}
+	if err = errors.Join(errs...); err != nil {
+		return nil, nil, p.RedactError(err)
+	}
+	for _, commit := range commits {
+		commit()
+	}
+	return p, profiles, nil
+}
+
+// merge merges all the enabled layers with the active profiles.
+func (c *Configuration) merge(profiles []string) (*conf.Properties, error) {
+	p := conf.New()
+	if c.canonicalKeys {
+		if err := p.EnableCanonicalKeys(); err != nil {
+			return nil, err
+		}
+	}
+	for _, l := range c.layers {
+		if l.disabled {
+			continue
+		}
+		if s, ok := l.source.(*PropertySources); ok {
+			s.profiles = profiles
+		}
+		if err := l.source.CopyTo(p.WithLayer(l.name)); err != nil {
 			return nil, err
 		}
 	}
 	return p, nil
 }
 
-// Refresh merges all layers and returned as a read-only properties.
-func (c *Configuration) Refresh() (ReadOnlyProperties, error) {
-	return merge(c.prop.Copy(), c.file, c.env, c.args, c.dync)
+// Bind binds the current properties into a value, the Refreshable values
+// in it, such as conf.Dynamic fields with a `value` tag, are recorded and
+// bound again on each refresh. Binding the same value again replaces its
+// record, so it's bound once per refresh with the latest BindParam.
+func (c *Configuration) Bind(i interface{}, args ...conf.BindArg) error {
+	c.refreshMutex.Lock()
+	defer c.refreshMutex.Unlock()
+
+	c.currentMutex.RLock()
+	p := c.current
+	c.currentMutex.RUnlock()
+
+	if p == nil {
+		return errors.New("configuration hasn't been refreshed")
+	}
+
+	var refreshables []refreshable
+	filter := func(i interface{}, param conf.BindParam) (bool, error) {
+		r, ok := i.(conf.Refreshable)
+		if !ok {
+			return false, nil
+		}
+		commit, err := r.OnRefresh(p, param)
+		if err != nil {
+			return false, err
+		}
+		commit()
+		refreshables = append(refreshables, refreshable{value: r, param: param})
+		return true, nil
+	}
+
+	if err := p.BindWithFilter(i, filter, args...); err != nil {
+		return err
+	}
+	for _, r := range refreshables {
+		c.addRefreshable(r)
+	}
+	return nil
+}
+
+// addRefreshable records a Refreshable, or replaces the record of the same
+// value.
+func (c *Configuration) addRefreshable(r refreshable) {
+	for i, v := range c.refreshables {
+		if v.value == r.value {
+			c.refreshables[i] = r
+			return
+		}
+	}
+	c.refreshables = append(c.refreshables, r)
+}
+
+// Current returns the last successfully refreshed properties, or nil if
+// the configuration has never been refreshed successfully.
+func (c *Configuration) Current() ReadOnlyProperties {
+	c.currentMutex.RLock()
+	defer c.currentMutex.RUnlock()
+	return readOnly(c.current)
+}
+
+// ActiveProfiles returns the active profiles of the current properties, the
+// profiles are decided by the ActiveProfilesKey property before loading the
+// profile-specific files and documents.
+func (c *Configuration) ActiveProfiles() []string {
+	c.currentMutex.RLock()
+	defer c.currentMutex.RUnlock()
+	return slices.Clone(c.profiles)
+}
+
+// readOnly avoids returning a non-nil interface holding a nil pointer.
+func readOnly(p *conf.Properties) ReadOnlyProperties {
+	if p == nil {
+		return nil
+	}
+	return p
 }
 
 /****************************** PropertySources ******************************/
 
-// PropertySources is a collection of property locations.
+// PropertySources is a collection of property locations, a location is a
+// file, a directory whose files are loaded in the order of their names, or
+// a glob pattern whose matched files are loaded in sorted order. The variants
+// of a file for the active profiles are loaded after it, and the documents
+// gated by OnProfileKey are loaded only when their profiles are active.
 type PropertySources struct {
+	guard
 	workDir   string
-	locations [][]string
+	locations []location
+	profiles  []string // active profiles
+	resolved  []string // resolved filenames of the last CopyTo
+}
+
+type location struct {
+	path     string
+	required bool
 }
 
 func NewPropertySources() *PropertySources {
 	return &PropertySources{}
 }
 
+// Add adds optional locations, the locations that don't exist are skipped.
 func (p *PropertySources) Add(location ...string) {
-	p.locations = append(p.locations, location)
+	p.add(false, location)
+}
+
+// Require adds required locations, the refresh fails when any of them
+// doesn't exist or a glob pattern of them matches no file.
+func (p *PropertySources) Require(location ...string) {
+	p.add(true, location)
+}
+
+func (p *PropertySources) add(required bool, ss []string) {
+	defer p.lock()()
+	for _, s := range ss {
+		p.locations = append(p.locations, location{path: s, required: required})
+	}
 }
 
 // Clear removes all locations.
 func (p *PropertySources) Clear() {
+	defer p.lock()()
 	p.locations = nil
 }
 
-// copyTo copies properties from the current layer to the output.
-func (p *PropertySources) copyTo(out *conf.Properties) error {
+// CopyTo copies properties from the locations to the output.
+func (p *PropertySources) CopyTo(out *conf.Properties) error {
 	workDir := p.workDir
 	if workDir == "" {
 		workDir, _ = os.Getwd()
 	}
-	for _, ss := range p.locations {
-		for _, s := range ss {
-			// resolve filename that maybe contains references
-			filename, err := out.Resolve(s)
-			if err != nil {
+	p.resolved = nil
+	for _, l := range p.locations {
+		// resolve filename that maybe contains references
+		filename, err := out.Resolve(l.path)
+		if err != nil {
+			return err
+		}
+		if !filepath.IsAbs(filename) {
+			filename = filepath.Join(workDir, filename)
+		}
+		files, err := p.expand(l, filename)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			p.resolved = append(p.resolved, file)
+			if err = p.load(out, file, false, nil); err != nil {
 				return err
 			}
-			if !filepath.IsAbs(filename) {
-				filename = filepath.Join(workDir, filename)
+		}
+	}
+	return nil
+}
+
+// expand returns the files of a location, the directory of a glob pattern
+// and a directory location are watched for added and removed files.
+func (p *PropertySources) expand(l location, filename string) ([]string, error) {
+
+	if isGlob(filename) {
+		matches, err := filepath.Glob(filename)
+		if err != nil {
+			return nil, fmt.Errorf("location %q (resolved %s) error, %w", l.path, filename, err)
+		}
+		if len(matches) == 0 && l.required {
+			err = fmt.Errorf("no file matches, %w", os.ErrNotExist)
+			return nil, fmt.Errorf("location %q (resolved %s) error, %w", l.path, filename, err)
+		}
+		sort.Strings(matches)
+		p.resolved = append(p.resolved, filepath.Dir(filename))
+		return matches, nil
+	}
+
+	fi, err := os.Stat(filename)
+	if err != nil && (l.required || !os.IsNotExist(err)) {
+		return nil, fmt.Errorf("location %q (resolved %s) error, %w", l.path, filename, err)
+	}
+
+	if err == nil && fi.IsDir() {
+		entries, err := os.ReadDir(filename)
+		if err != nil {
+			return nil, fmt.Errorf("location %q (resolved %s) error, %w", l.path, filename, err)
+		}
+		var files []string
+		for _, e := range entries {
+			if !e.IsDir() && conf.HasReader(filepath.Ext(e.Name())) {
+				files = append(files, filepath.Join(filename, e.Name()))
+			}
+		}
+		p.resolved = append(p.resolved, filename)
+		return files, nil
+	}
+
+	files := []string{filename}
+	for _, profile := range p.profiles {
+		files = append(files, profileFile(filename, profile))
+	}
+	return files, nil
+}
+
+// isGlob returns whether the path contains any glob meta character.
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// load copies the active documents of the file and the files it imports to
+// the output, a missing file is skipped unless it's required.
+func (p *PropertySources) load(out *conf.Properties, filename string, required bool, stack []string) error {
+	docs, err := conf.LoadDocuments(filename)
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			return nil
+		}
+		return err
+	}
+	var imports []string
+	o := out.WithSource(filename)
+	for _, doc := range activeDocuments(docs, p.profiles) {
+		for _, key := range doc.Keys() {
+			if isReservedKey(key) {
+				continue
+			}
+			w := o
+			if origin, ok := doc.Origin(key); ok && origin.Line > 0 {
+				w = o.WithLine(origin.Line)
 			}
-			c, err := conf.Load(filename)
-			if err != nil {
-				if os.IsNotExist(err) {
-					continue
-				}
+			if err = w.Set(key, doc.Get(key)); err != nil {
 				return err
 			}
-			keys := c.Keys()
-			for _, key := range keys {
-				err = out.Set(key, c.Get(key))
-				if err != nil {
-					return err
-				}
-			}
 		}
+		imports = append(imports, stringList(doc, ImportKey)...)
 	}
-	return nil
+	return p.loadImports(out, filename, imports, append(stack, filename))
 }
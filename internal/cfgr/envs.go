@@ -15,8 +15,10 @@
 package cfgr
 
 import (
+	"encoding/json"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/lvan100/go-conf/internal/conf"
@@ -27,10 +29,16 @@ const (
 	ExcludeEnvPatterns = "EXCLUDE_ENV_PATTERNS"
 )
 
-// Environment environment variable
+// Environment environment variable, the variables with the prefix, or
+// matching IncludeEnvPatterns, are mapped to the properties by EnvKey
+// unless another mapper is set by SetKeyMapper.
 type Environment struct {
-	prefix  string
-	environ []string
+	guard
+	prefix        string
+	environ       []string
+	keyMapper     func(name string) string
+	jsonValues    bool
+	indexSegments bool
 }
 
 func NewEnvironment() *Environment {
@@ -41,13 +49,82 @@ func NewEnvironment() *Environment {
 }
 
 func (c *Environment) Reset(environ []string) {
+	defer c.lock()()
 	c.environ = environ
 }
 
 func (c *Environment) SetPrefix(prefix string) {
+	defer c.lock()()
 	c.prefix = prefix
 }
 
+// SetKeyMapper sets the function that maps the name of a variable, whose
+// prefix has been trimmed, to the property key, the variable is skipped when
+// it returns an empty key. The default mapper is EnvKey, and a nil mapper
+// restores it.
+func (c *Environment) SetKeyMapper(fn func(name string) string) {
+	defer c.lock()()
+	c.keyMapper = fn
+}
+
+// SetIndexSegments makes the numeric segments of the names map to the array
+// indexes, such as SERVER_PORTS_1 into server.ports[1], by default they are
+// map keys, such as CODES_404 into codes.404.
+func (c *Environment) SetIndexSegments(enable bool) {
+	defer c.lock()()
+	c.indexSegments = enable
+}
+
+// SetJSONValues makes the values that are JSON arrays or objects flattened
+// into the sub keys, such as GS_DB_HOSTS='["a","b"]' into db.hosts[0] and
+// db.hosts[1]. The values that aren't valid JSON are set as they are.
+func (c *Environment) SetJSONValues(enable bool) {
+	defer c.lock()()
+	c.jsonValues = enable
+}
+
+// EnvKey maps the name of a variable to the property key, a `_` separates
+// the key segments, a `__` is a literal `_`, and the key is lowercase, such
+// as DB_MAX__CONNS into db.max_conns. A numeric segment is an index when
+// indexes is true, such as SERVER_PORTS_1 into server.ports[1], otherwise a
+// map key. The empty segments are ignored.
+func EnvKey(name string, indexes bool) string {
+	var (
+		segs []string
+		seg  strings.Builder
+	)
+	for i := 0; i < len(name); i++ {
+		if name[i] != '_' {
+			seg.WriteByte(name[i])
+			continue
+		}
+		if i+1 < len(name) && name[i+1] == '_' {
+			seg.WriteByte('_')
+			i++
+			continue
+		}
+		segs = append(segs, seg.String())
+		seg.Reset()
+	}
+	segs = append(segs, seg.String())
+
+	var sb strings.Builder
+	for _, s := range segs {
+		if s == "" {
+			continue
+		}
+		if _, err := strconv.ParseUint(s, 10, 64); err == nil && indexes && sb.Len() > 0 {
+			sb.WriteString("[" + s + "]")
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteString(".")
+		}
+		sb.WriteString(strings.ToLower(s))
+	}
+	return sb.String()
+}
+
 func (c *Environment) lookupEnv(key string) (value string, found bool) {
 	key = strings.TrimSpace(key) + "="
 	for _, s := range c.environ {
@@ -59,9 +136,9 @@ func (c *Environment) lookupEnv(key string) (value string, found bool) {
 	return "", false
 }
 
-// copyTo add environment variables that matches IncludeEnvPatterns and
+// CopyTo add environment variables that matches IncludeEnvPatterns and
 // exclude environment variables that matches ExcludeEnvPatterns.
-func (c *Environment) copyTo(p *conf.Properties) error {
+func (c *Environment) CopyTo(p *conf.Properties) error {
 
 	toRex := func(patterns []string) ([]*regexp.Regexp, error) {
 		var rex []*regexp.Regexp
@@ -118,11 +195,34 @@ func (c *Environment) copyTo(p *conf.Properties) error {
 			continue
 		}
 
-		propKey = strings.ReplaceAll(propKey, "_", ".")
-		propKey = strings.ToLower(propKey)
-		if err = p.Set(propKey, v); err != nil {
+		if c.keyMapper != nil {
+			propKey = c.keyMapper(propKey)
+		} else {
+			propKey = EnvKey(propKey, c.indexSegments)
+		}
+		if propKey == "" {
+			continue
+		}
+		if err = p.WithSource(k).Set(propKey, c.value(v)); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// value returns the decoded JSON array or object when the JSON values are
+// enabled, or the value itself.
+func (c *Environment) value(v string) interface{} {
+	if !c.jsonValues {
+		return v
+	}
+	s := strings.TrimSpace(v)
+	if !strings.HasPrefix(s, "[") && !strings.HasPrefix(s, "{") {
+		return v
+	}
+	var ret interface{}
+	if err := json.Unmarshal([]byte(s), &ret); err != nil {
+		return v
+	}
+	return ret
+}
@@ -0,0 +1,300 @@
+// Copyright 2024 github.com/lvan100
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cfgr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/lvan100/go-conf/internal/conf"
+)
+
+// contentTypes maps the media types to the file name extensions of readers.
+var contentTypes = map[string]string{
+	"application/json":       ".json",
+	"application/yaml":       ".yaml",
+	"application/x-yaml":     ".yaml",
+	"text/yaml":              ".yaml",
+	"text/x-yaml":            ".yaml",
+	"application/toml":       ".toml",
+	"text/x-java-properties": ".properties",
+}
+
+// HTTPSource is a Source that fetches properties from a URL. The format is
+// decided by the content type of the response, or by the extension of the
+// URL path when the content type is unknown. Each fetch sends the ETag of
+// the last response in If-None-Match, so an unchanged content costs only a
+// 304 response. When the server is unreachable, the last fetched content is
+// used, and at startup it's loaded from the cache file if there is one. The
+// errors are reported by OnError and LastError.
+type HTTPSource struct {
+	errorReporter
+
+	url       string
+	client    *http.Client
+	format    string
+	cacheFile string
+	interval  time.Duration
+	timeout   time.Duration
+
+	mutex    sync.Mutex
+	content  *httpContent // the last known good content
+	watching int          // number of the running Watch calls
+}
+
+// httpContent is the content of a response, it's saved in the cache file.
+type httpContent struct {
+	ETag string `json:"etag"`
+	Ext  string `json:"ext"`
+	Body []byte `json:"body"`
+}
+
+const (
+	defaultHTTPInterval = 30 * time.Second
+	defaultHTTPTimeout  = 10 * time.Second
+)
+
+func NewHTTPSource(url string) *HTTPSource {
+	return &HTTPSource{
+		url:      url,
+		client:   &http.Client{},
+		interval: defaultHTTPInterval,
+		timeout:  defaultHTTPTimeout,
+	}
+}
+
+// SetClient sets the client used to fetch the URL.
+func (s *HTTPSource) SetClient(client *http.Client) {
+	s.client = client
+}
+
+// SetFormat sets the file name extension of the format, such as ".yaml",
+// which takes precedence over the content type and the URL path.
+func (s *HTTPSource) SetFormat(ext string) {
+	s.format = ext
+}
+
+// SetCacheFile sets the file that keeps the last fetched content.
+func (s *HTTPSource) SetCacheFile(file string) {
+	s.cacheFile = file
+}
+
+// SetInterval sets the polling interval used by Watch, default 30s, a
+// non-positive interval restores the default.
+func (s *HTTPSource) SetInterval(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultHTTPInterval
+	}
+	s.interval = interval
+}
+
+// SetTimeout sets the timeout of each fetch whatever the client is, default
+// 10s, a non-positive timeout restores the default. A refresh waits for the
+// fetch when the source isn't watched, so it shouldn't be too long.
+func (s *HTTPSource) SetTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = defaultHTTPTimeout
+	}
+	s.timeout = timeout
+}
+
+// CopyTo copies the properties of the last fetched content to the output.
+// While the source is watched, the content fetched by Watch is reused, or
+// the URL is fetched first. It fails only when there is no content fetched
+// or cached.
+func (s *HTTPSource) CopyTo(out *conf.Properties) error {
+	s.mutex.Lock()
+	reuse := s.watching > 0 && s.content != nil
+	s.mutex.Unlock()
+
+	var err error
+	if !reuse {
+		_, err = s.fetch(context.Background())
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err != nil && s.content == nil {
+		return err
+	}
+	p := conf.New()
+	if err = p.Bytes(s.content.Body, s.content.Ext); err != nil {
+		return fmt.Errorf("read %s error, %w", s.url, err)
+	}
+	return p.CopyTo(out.WithSource(s.url))
+}
+
+// Watch polls the URL until ctx is done, and calls changed when the content
+// changes.
+func (s *HTTPSource) Watch(ctx context.Context, changed func()) {
+	s.mutex.Lock()
+	s.watching++
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		s.watching--
+		s.mutex.Unlock()
+	}()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if ok, _ := s.fetch(ctx); ok {
+			changed()
+		}
+	}
+}
+
+// fetch requests the URL and reports the error, unless ctx is done, and
+// returns whether the content changed.
+func (s *HTTPSource) fetch(ctx context.Context) (bool, error) {
+	changed, err := s.request(ctx)
+	if ctx.Err() == nil {
+		s.report(err)
+	}
+	return changed, err
+}
+
+// request requests the URL with the ETag of the current content, and returns
+// whether the content changed. The mutex isn't held during the request, so a
+// slow server doesn't block the readers of the current content.
+func (s *HTTPSource) request(ctx context.Context) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	s.mutex.Lock()
+	if s.content == nil && s.cacheFile != "" {
+		s.content = readCache(s.cacheFile)
+	}
+	var etag string
+	if s.content != nil {
+		etag = s.content.ETag
+	}
+	s.mutex.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return false, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("fetch %s error, %w", s.url, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode == http.StatusNotModified && etag != "" {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("fetch %s error, status %s", s.url, resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, fmt.Errorf("fetch %s error, %w", s.url, err)
+	}
+	ext, err := s.ext(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return false, err
+	}
+
+	c := &httpContent{ETag: resp.Header.Get("ETag"), Ext: ext, Body: body}
+	if err = conf.New().Bytes(body, ext); err != nil {
+		return false, fmt.Errorf("read %s error, %w", s.url, err)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	changed := s.content == nil || s.content.Ext != ext || !bytes.Equal(s.content.Body, body)
+	s.content = c
+	if s.cacheFile != "" {
+		if err = writeCache(s.cacheFile, c); err != nil {
+			return changed, err
+		}
+	}
+	return changed, nil
+}
+
+// ext returns the file name extension of the format of the response.
+func (s *HTTPSource) ext(contentType string) (string, error) {
+	if s.format != "" {
+		return s.format, nil
+	}
+	if t, _, err := mime.ParseMediaType(contentType); err == nil {
+		if ext, ok := contentTypes[t]; ok {
+			return ext, nil
+		}
+	}
+	if u, err := url.Parse(s.url); err == nil {
+		if ext := path.Ext(u.Path); conf.HasReader(ext) {
+			return ext, nil
+		}
+	}
+	return "", fmt.Errorf("fetch %s error, unsupported content type %q", s.url, contentType)
+}
+
+// readCache returns nil when the cache file doesn't exist or is broken.
+func readCache(file string) *httpContent {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	var c httpContent
+	if err = json.Unmarshal(b, &c); err != nil {
+		return nil
+	}
+	return &c
+}
+
+// writeCache writes the cache file by renaming, so that a broken cache file
+// won't be left when the process exits during the writing.
+func writeCache(file string, c *httpContent) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
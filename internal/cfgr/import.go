@@ -0,0 +1,93 @@
+// Copyright 2024 github.com/lvan100
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cfgr
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/lvan100/go-conf/internal/conf"
+)
+
+const (
+	// ImportKey lists the files imported by a file, it can be a comma
+	// separated string or a list. The relative paths are relative to the
+	// importing file, and the imported files override the importing file.
+	ImportKey = "conf.import"
+
+	// OptionalPrefix marks an imported file that may not exist.
+	OptionalPrefix = "optional:"
+)
+
+// isReservedKey returns whether the key is processed when loading files and
+// isn't copied to the output.
+func isReservedKey(key string) bool {
+	for _, s := range []string{OnProfileKey, ImportKey} {
+		if key == s || strings.HasPrefix(key, s+"[") {
+			return true
+		}
+	}
+	return false
+}
+
+// stringList returns the values of the key which can be a comma separated
+// string or a list, the empty values are removed.
+func stringList(p *conf.Properties, key string) []string {
+	var ss []string
+	if !p.Has(key + "[0]") {
+		ss = strings.Split(p.Get(key), ",")
+	} else {
+		for i := 0; ; i++ {
+			k := fmt.Sprintf("%s[%d]", key, i)
+			if !p.Has(k) {
+				break
+			}
+			ss = append(ss, strings.Split(p.Get(k), ",")...)
+		}
+	}
+	var ret []string
+	for _, s := range ss {
+		if s = strings.TrimSpace(s); s != "" {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+// loadImports loads the files imported by the file in order, `stack` is the
+// chain of the importing files, used to detect import cycles.
+func (p *PropertySources) loadImports(out *conf.Properties, filename string, imports []string, stack []string) error {
+	for _, s := range imports {
+		optional := strings.HasPrefix(s, OptionalPrefix)
+		file, err := out.Resolve(strings.TrimPrefix(s, OptionalPrefix))
+		if err != nil {
+			return fmt.Errorf("import %q in %s error, %w", s, filename, err)
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(filepath.Dir(filename), file)
+		}
+		if slices.Contains(stack, file) {
+			chain := strings.Join(append(stack, file), " -> ")
+			return fmt.Errorf("import %q in %s error, import cycle %s", s, filename, chain)
+		}
+		p.resolved = append(p.resolved, file)
+		if err = p.load(out, file, !optional, stack); err != nil {
+			return fmt.Errorf("import %q in %s error, %w", s, filename, err)
+		}
+	}
+	return nil
+}
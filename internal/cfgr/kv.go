@@ -0,0 +1,395 @@
+// Copyright 2024 github.com/lvan100
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cfgr
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lvan100/go-conf/internal/conf"
+)
+
+// KVSource is a Source that loads the keys under a prefix of a KV store, the
+// slash-separated keys are mapped to the property keys, such as `app/db/host`
+// with the prefix `app` to `db.host`, and the numeric segments are mapped to
+// the map keys, or to the indexes by SetIndexSegments. It's watched by the
+// blocking queries of Consul, or the watch API of etcd, through their HTTP
+// APIs. The errors are reported by OnError and LastError.
+type KVSource struct {
+	errorReporter
+
+	addr          string
+	prefix        string
+	client        *http.Client
+	token         string
+	wait          time.Duration
+	retry         time.Duration
+	indexSegments bool
+	list          func(ctx context.Context, index uint64) ([]kvPair, uint64, error)
+
+	mutex  sync.Mutex
+	pairs  []kvPair // the last known good pairs
+	index  uint64
+	loaded bool
+}
+
+type kvPair struct {
+	key   string
+	value string
+}
+
+func newKVSource(addr, prefix string) *KVSource {
+	prefix = strings.TrimPrefix(prefix, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &KVSource{
+		addr:   strings.TrimSuffix(addr, "/"),
+		prefix: prefix,
+		client: &http.Client{},
+		wait:   5 * time.Minute,
+		retry:  5 * time.Second,
+	}
+}
+
+// NewConsulSource returns a KVSource of the Consul agent at addr, such as
+// `http://127.0.0.1:8500`.
+func NewConsulSource(addr, prefix string) *KVSource {
+	s := newKVSource(addr, prefix)
+	s.list = s.listConsul
+	return s
+}
+
+// NewEtcdSource returns a KVSource of the etcd server at addr, such as
+// `http://127.0.0.1:2379`, through its v3 JSON gateway.
+func NewEtcdSource(addr, prefix string) *KVSource {
+	s := newKVSource(addr, prefix)
+	s.list = s.listEtcd
+	return s
+}
+
+// SetClient sets the client used to request the store.
+func (s *KVSource) SetClient(client *http.Client) {
+	s.client = client
+}
+
+// SetToken sets the ACL token of Consul, or the auth token of etcd.
+func (s *KVSource) SetToken(token string) {
+	s.token = token
+}
+
+// SetIndexSegments makes the numeric segments of the keys map to the array
+// indexes, such as `app/servers/0/host` to `servers[0].host`, by default
+// they are map keys, such as `app/codes/404` to `codes.404`.
+func (s *KVSource) SetIndexSegments(enable bool) {
+	s.indexSegments = enable
+}
+
+// SetWait sets the longest time that a watch request blocks, default 5m.
+func (s *KVSource) SetWait(wait time.Duration) {
+	s.wait = wait
+}
+
+// CopyTo lists the keys under the prefix and copies them to the output, it
+// fails only when the keys have never been listed successfully.
+func (s *KVSource) CopyTo(out *conf.Properties) error {
+	pairs, index, err := s.list(context.Background(), 0)
+	s.report(err)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err != nil && !s.loaded {
+		return err
+	}
+	if err == nil {
+		s.update(pairs, index)
+	}
+	for _, pair := range s.pairs {
+		key := kvKey(s.prefix, pair.key, s.indexSegments)
+		if key == "" {
+			continue
+		}
+		if err = out.WithSource(pair.key).Set(key, pair.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Watch blocks on the changes of the keys under the prefix until ctx is
+// done, and calls changed when any of them changes.
+func (s *KVSource) Watch(ctx context.Context, changed func()) {
+	for {
+		s.mutex.Lock()
+		index := s.index
+		s.mutex.Unlock()
+
+		pairs, index, err := s.list(ctx, index)
+		if ctx.Err() != nil {
+			return
+		}
+		s.report(err)
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.retry):
+				continue
+			}
+		}
+
+		s.mutex.Lock()
+		ok := s.update(pairs, index)
+		s.mutex.Unlock()
+		if ok {
+			changed()
+		}
+	}
+}
+
+// update updates the pairs and returns whether they changed, it must be
+// called with the mutex held.
+func (s *KVSource) update(pairs []kvPair, index uint64) bool {
+	changed := !s.loaded || !slices.Equal(s.pairs, pairs)
+	s.pairs, s.index, s.loaded = pairs, index, true
+	return changed
+}
+
+// kvKey converts a key of the store to a property key, the numeric segments
+// are indexes when index is true.
+func kvKey(prefix, key string, index bool) string {
+	var sb strings.Builder
+	for _, seg := range strings.Split(strings.TrimPrefix(key, prefix), "/") {
+		if seg == "" {
+			continue
+		}
+		if _, err := strconv.ParseUint(seg, 10, 64); err == nil && index && sb.Len() > 0 {
+			sb.WriteString("[" + seg + "]")
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteString(".")
+		}
+		sb.WriteString(seg)
+	}
+	return sb.String()
+}
+
+// timeout returns the timeout of a request, a watch request blocks at most
+// the wait time.
+func (s *KVSource) timeout(index uint64) time.Duration {
+	if index > 0 {
+		return s.wait + 10*time.Second
+	}
+	return 10 * time.Second
+}
+
+// listConsul lists the keys by the recurse query of Consul, which blocks
+// until the index of the keys changes or the wait time passes when index
+// is not 0.
+func (s *KVSource) listConsul(ctx context.Context, index uint64) ([]kvPair, uint64, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout(index))
+	defer cancel()
+
+	q := url.Values{"recurse": {"true"}}
+	if index > 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+		q.Set("wait", s.wait.String())
+	}
+	u := s.addr + "/v1/kv/" + s.prefix + "?" + q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if s.token != "" {
+		req.Header.Set("X-Consul-Token", s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("list %s error, %w", u, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return nil, 0, fmt.Errorf("list %s error, status %s", u, resp.Status)
+	}
+	newIndex, err := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("list %s error, bad X-Consul-Index, %w", u, err)
+	}
+	if newIndex == 0 {
+		newIndex = 1 // the index 0 makes the next query not blocking
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, newIndex, nil
+	}
+
+	var entries []struct {
+		Key   string
+		Value []byte
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, fmt.Errorf("list %s error, %w", u, err)
+	}
+	var pairs []kvPair
+	for _, e := range entries {
+		if e.Value == nil && strings.HasSuffix(e.Key, "/") {
+			continue // folder
+		}
+		pairs = append(pairs, kvPair{key: e.Key, value: string(e.Value)})
+	}
+	return pairs, newIndex, nil
+}
+
+// etcdKeyValue is the key-value of the etcd v3 JSON gateway, whose bytes are
+// encoded in base64 and integers are encoded in strings.
+type etcdKeyValue struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+type etcdHeader struct {
+	Revision int64 `json:"revision,string"`
+}
+
+// listEtcd lists the keys by the range API of etcd, when index is not 0 it
+// watches the keys from the next revision and lists them after any event
+// or the wait time passes.
+func (s *KVSource) listEtcd(ctx context.Context, index uint64) ([]kvPair, uint64, error) {
+	if index > 0 {
+		if err := s.watchEtcd(ctx, index+1); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout(0))
+	defer cancel()
+
+	var resp struct {
+		Header etcdHeader     `json:"header"`
+		Kvs    []etcdKeyValue `json:"kvs"`
+	}
+	u := s.addr + "/v3/kv/range"
+	body, err := s.postEtcd(ctx, u)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() { _ = body.Close() }()
+	if err = json.NewDecoder(body).Decode(&resp); err != nil {
+		return nil, 0, fmt.Errorf("list %s error, %w", u, err)
+	}
+	var pairs []kvPair
+	for _, kv := range resp.Kvs {
+		pairs = append(pairs, kvPair{key: string(kv.Key), value: string(kv.Value)})
+	}
+	return pairs, uint64(resp.Header.Revision), nil
+}
+
+// watchEtcd returns when any event of the keys happens from the revision,
+// or the wait time passes.
+func (s *KVSource) watchEtcd(ctx context.Context, revision uint64) error {
+	wctx, cancel := context.WithTimeout(ctx, s.wait)
+	defer cancel()
+
+	u := s.addr + "/v3/watch"
+	body, err := s.postEtcd(wctx, u, revision)
+	if err != nil {
+		if ctx.Err() == nil && wctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	defer func() { _ = body.Close() }()
+
+	d := json.NewDecoder(body)
+	for {
+		var resp struct {
+			Result struct {
+				Canceled        bool              `json:"canceled"`
+				CompactRevision int64             `json:"compact_revision,string"`
+				Events          []json.RawMessage `json:"events"`
+			} `json:"result"`
+		}
+		if err = d.Decode(&resp); err != nil {
+			if ctx.Err() == nil && wctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("watch %s error, %w", u, err)
+		}
+		r := resp.Result
+		if r.Canceled || r.CompactRevision > 0 || len(r.Events) > 0 {
+			return nil
+		}
+	}
+}
+
+// postEtcd posts a range request, or a watch request from the revision,
+// of the keys under the prefix.
+func (s *KVSource) postEtcd(ctx context.Context, u string, revision ...uint64) (io.ReadCloser, error) {
+	r := map[string]string{
+		"key":       base64.StdEncoding.EncodeToString([]byte(s.prefix)),
+		"range_end": base64.StdEncoding.EncodeToString(prefixEnd(s.prefix)),
+	}
+	var req interface{} = r
+	if len(revision) > 0 {
+		r["start_revision"] = strconv.FormatUint(revision[0], 10)
+		req = map[string]interface{}{"create_request": r}
+	}
+	b, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		hreq.Header.Set("Authorization", s.token)
+	}
+	resp, err := s.client.Do(hreq)
+	if err != nil {
+		return nil, fmt.Errorf("post %s error, %w", u, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("post %s error, status %s", u, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// prefixEnd returns the range end of the keys with the prefix, which is the
+// prefix with the last byte increased, or "\x00" which means all the keys.
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0}
+}
@@ -0,0 +1,195 @@
+// Copyright 2024 github.com/lvan100
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cfgr
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/lvan100/go-conf/internal/conf"
+)
+
+// Source is the source of properties of a layer.
+type Source interface {
+
+	// CopyTo copies the properties of the source to the output, the output
+	// records the layer name as the origin of the properties.
+	CopyTo(out *conf.Properties) error
+}
+
+// Watcher is a Source that knows when its properties change, such as a
+// remote source that polls the server. Configuration.Watch starts watching
+// the Watchers of the enabled layers.
+type Watcher interface {
+	Source
+
+	// Watch blocks until ctx is done, and calls changed each time the
+	// properties of the source change.
+	Watch(ctx context.Context, changed func())
+}
+
+// LayerInfo describes a layer of Configuration.
+type LayerInfo struct {
+	Name    string
+	Enabled bool
+	Source  Source
+}
+
+type layer struct {
+	name     string
+	source   Source
+	disabled bool
+}
+
+// Layers returns the layers in the order of precedence, the properties in
+// the later layers override the earlier ones.
+func (c *Configuration) Layers() []LayerInfo {
+	c.refreshMutex.Lock()
+	defer c.refreshMutex.Unlock()
+	ret := make([]LayerInfo, len(c.layers))
+	for i, l := range c.layers {
+		ret[i] = LayerInfo{Name: l.name, Enabled: !l.disabled, Source: l.source}
+	}
+	return ret
+}
+
+// AddLayer adds a layer that overrides all the existing layers.
+func (c *Configuration) AddLayer(name string, s Source) error {
+	c.refreshMutex.Lock()
+	defer c.refreshMutex.Unlock()
+	return c.insertLayer(len(c.layers), name, s)
+}
+
+// InsertLayerBefore adds a layer that is overridden by the layer `before`.
+func (c *Configuration) InsertLayerBefore(before string, name string, s Source) error {
+	c.refreshMutex.Lock()
+	defer c.refreshMutex.Unlock()
+	i, err := c.layerIndex(before)
+	if err != nil {
+		return err
+	}
+	return c.insertLayer(i, name, s)
+}
+
+// InsertLayerAfter adds a layer that overrides the layer `after`.
+func (c *Configuration) InsertLayerAfter(after string, name string, s Source) error {
+	c.refreshMutex.Lock()
+	defer c.refreshMutex.Unlock()
+	i, err := c.layerIndex(after)
+	if err != nil {
+		return err
+	}
+	return c.insertLayer(i+1, name, s)
+}
+
+// RemoveLayer removes a layer.
+func (c *Configuration) RemoveLayer(name string) error {
+	c.refreshMutex.Lock()
+	defer c.refreshMutex.Unlock()
+	i, err := c.layerIndex(name)
+	if err != nil {
+		return err
+	}
+	c.layers = slices.Delete(c.layers, i, i+1)
+	return nil
+}
+
+// EnableLayer enables or disables a layer, a disabled layer is skipped
+// when refreshing.
+func (c *Configuration) EnableLayer(name string, enable bool) error {
+	c.refreshMutex.Lock()
+	defer c.refreshMutex.Unlock()
+	i, err := c.layerIndex(name)
+	if err != nil {
+		return err
+	}
+	c.layers[i].disabled = !enable
+	return nil
+}
+
+func (c *Configuration) insertLayer(i int, name string, s Source) error {
+	if name == "" {
+		return fmt.Errorf("layer name is empty")
+	}
+	if s == nil {
+		return fmt.Errorf("layer %q has no source", name)
+	}
+	if _, err := c.layerIndex(name); err == nil {
+		return fmt.Errorf("layer %q already exists", name)
+	}
+	c.layers = slices.Insert(c.layers, i, &layer{name: name, source: s})
+	return nil
+}
+
+func (c *Configuration) layerIndex(name string) (int, error) {
+	for i, l := range c.layers {
+		if l.name == name {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("layer %q not found", name)
+}
+
+// guard is the mutex of the Configuration that owns a source, the mutators
+// of the source hold it so that they don't race with the refreshes.
+type guard struct {
+	mutex *sync.Mutex
+}
+
+// lock locks the mutex if any, and returns the function that unlocks it.
+func (g guard) lock() func() {
+	if g.mutex == nil {
+		return func() {}
+	}
+	g.mutex.Lock()
+	return g.mutex.Unlock
+}
+
+// errorReporter keeps the last error of a remote source, and reports each
+// error to the handler set by OnError, since a source keeps serving its last
+// known good content when it fails.
+type errorReporter struct {
+	errMutex sync.Mutex
+	handler  func(error)
+	lastErr  error
+}
+
+// OnError sets the handler called with each error of the source, it may be
+// called during a refresh, so it must not call the Configuration mutators.
+func (r *errorReporter) OnError(fn func(error)) {
+	r.errMutex.Lock()
+	defer r.errMutex.Unlock()
+	r.handler = fn
+}
+
+// LastError returns the error of the last request, or nil if it succeeded.
+func (r *errorReporter) LastError() error {
+	r.errMutex.Lock()
+	defer r.errMutex.Unlock()
+	return r.lastErr
+}
+
+// report records the result of a request, and calls the handler on error.
+func (r *errorReporter) report(err error) {
+	r.errMutex.Lock()
+	r.lastErr = err
+	handler := r.handler
+	r.errMutex.Unlock()
+	if err != nil && handler != nil {
+		handler(err)
+	}
+}
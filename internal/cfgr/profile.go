@@ -0,0 +1,107 @@
+// Copyright 2024 github.com/lvan100
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cfgr
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/lvan100/go-conf/internal/conf"
+)
+
+const (
+	// ActiveProfilesKey is the property of the active profiles separated by
+	// comma, the properties of the later profiles override the earlier ones.
+	ActiveProfilesKey = "spring.profiles.active"
+
+	// OnProfileKey gates a document of a multi-document file, the document
+	// is loaded only when one of the profiles it lists is active.
+	OnProfileKey = "spring.config.activate.on-profile"
+)
+
+// activeProfiles returns the active profiles of the properties.
+func activeProfiles(p *conf.Properties) ([]string, error) {
+	if !p.Has(ActiveProfilesKey) {
+		return nil, nil
+	}
+	s, err := p.Resolve(p.Get(ActiveProfilesKey))
+	if err != nil {
+		return nil, err
+	}
+	return splitProfiles(s), nil
+}
+
+// splitProfiles splits a comma separated list, the empty and the duplicate
+// profiles are removed.
+func splitProfiles(s string) []string {
+	var profiles []string
+	for _, profile := range strings.Split(s, ",") {
+		profile = strings.TrimSpace(profile)
+		if profile != "" && !slices.Contains(profiles, profile) {
+			profiles = append(profiles, profile)
+		}
+	}
+	return profiles
+}
+
+// profileFile returns the profile-specific variant of the file, for example,
+// conf-dev.yaml is the variant of conf.yaml for the profile dev.
+func profileFile(file string, profile string) string {
+	ext := filepath.Ext(file)
+	return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(file, ext), profile, ext)
+}
+
+// documentProfiles returns the profiles listed by OnProfileKey of a document.
+func documentProfiles(doc *conf.Properties) []string {
+	return stringList(doc, OnProfileKey)
+}
+
+// activeDocuments returns the documents that should be loaded, the documents
+// without OnProfileKey come first in their order, then the gated documents
+// in the order of their active profiles, a document that lists several
+// active profiles is placed at the last one of them.
+func activeDocuments(docs []*conf.Properties, profiles []string) []*conf.Properties {
+	type document struct {
+		doc  *conf.Properties
+		rank int
+	}
+	var ret []document
+	for _, doc := range docs {
+		if !doc.Has(OnProfileKey) {
+			ret = append(ret, document{doc, -1})
+			continue
+		}
+		rank := -1
+		for _, profile := range documentProfiles(doc) {
+			if i := slices.Index(profiles, profile); i > rank {
+				rank = i
+			}
+		}
+		if rank >= 0 {
+			ret = append(ret, document{doc, rank})
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].rank < ret[j].rank
+	})
+	result := make([]*conf.Properties, len(ret))
+	for i, d := range ret {
+		result[i] = d.doc
+	}
+	return result
+}
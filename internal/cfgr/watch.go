@@ -0,0 +1,225 @@
+// Copyright 2024 github.com/lvan100
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cfgr
+
+import (
+	"context"
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lvan100/go-conf/internal/conf"
+)
+
+// RefreshListener is notified after each refresh. When the refresh fails,
+// `new` is nil and `err` is the reason, and `old` is still the current one.
+type RefreshListener func(old, new ReadOnlyProperties, err error)
+
+// Subscribe adds a listener that is notified after each refresh. Listeners
+// are called synchronously in the refreshing goroutine, so they shouldn't
+// call Refresh again.
+func (c *Configuration) Subscribe(fn RefreshListener) {
+	c.refreshMutex.Lock()
+	defer c.refreshMutex.Unlock()
+	c.listeners = append(c.listeners, fn)
+}
+
+// notify calls all listeners, it must be called with refreshMutex held.
+func (c *Configuration) notify(old, new *conf.Properties, err error) {
+	for _, fn := range c.listeners {
+		fn(readOnly(old), readOnly(new), err)
+	}
+	if err != nil || len(c.keyListeners) == 0 {
+		return
+	}
+	changes := conf.Diff(old, new)
+	for _, l := range c.keyListeners {
+		var matched []conf.Change
+		for _, change := range changes {
+			if l.pattern.MatchString(change.Key) {
+				matched = append(matched, change)
+			}
+		}
+		if len(matched) > 0 {
+			l.fn(matched)
+		}
+	}
+}
+
+// KeyListener is notified with the changes of the keys matching its pattern,
+// it is only called when a refresh succeeded and some of the keys changed.
+type KeyListener func(changes []conf.Change)
+
+type keyListener struct {
+	pattern *regexp.Regexp
+	fn      KeyListener
+}
+
+// SubscribeKeys adds a listener for the keys matching the pattern. A pattern
+// ending with '.' matches the keys starting with it, such as `db.`, other
+// patterns match the key itself and all its sub keys, such as `server.ports`.
+// In a pattern, `[*]` matches any index, and `*` matches any single key, so
+// `server.ports[*].host` matches `server.ports[0].host`. An empty pattern
+// matches all the keys.
+func (c *Configuration) SubscribeKeys(pattern string, fn KeyListener) {
+	c.refreshMutex.Lock()
+	defer c.refreshMutex.Unlock()
+	c.keyListeners = append(c.keyListeners, keyListener{
+		pattern: compileKeyPattern(pattern),
+		fn:      fn,
+	})
+}
+
+// compileKeyPattern converts a key pattern into a regular expression.
+func compileKeyPattern(pattern string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for s := pattern; s != ""; {
+		switch {
+		case strings.HasPrefix(s, "[*]"):
+			sb.WriteString(`\[\d+\]`)
+			s = s[3:]
+		case s[0] == '*':
+			sb.WriteString(`[^.\[\]]+`)
+			s = s[1:]
+		default:
+			sb.WriteString(regexp.QuoteMeta(s[:1]))
+			s = s[1:]
+		}
+	}
+	if pattern != "" && !strings.HasSuffix(pattern, ".") && !strings.HasSuffix(pattern, "[") {
+		sb.WriteString(`($|[.\[])`)
+	}
+	return regexp.MustCompile(sb.String())
+}
+
+// Watch refreshes the configuration once, and then keeps watching all the
+// resolved locations of the PropertySources layers until ctx is done. Files
+// are polled every interval, and a refresh happens only after the files
+// stay unchanged for a whole interval, so that a file written in several
+// steps triggers only one refresh. The layers whose sources are Watchers
+// are watched by themselves, and a refresh happens on each of their change.
+// The result of each refresh is reported to the subscribers, and the last
+// good snapshot is kept when it failed.
+func (c *Configuration) Watch(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return errors.New("watch interval should be positive")
+	}
+	if _, err := c.Refresh(); err != nil {
+		return err
+	}
+	files := c.watchedFiles()
+	go c.watch(ctx, interval, files, statFiles(files))
+	for _, w := range c.watchers() {
+		go w.Watch(ctx, func() {
+			_, _ = c.Refresh() // the error has been reported to the subscribers
+		})
+	}
+	return nil
+}
+
+// watchers returns the Watchers of the enabled layers.
+func (c *Configuration) watchers() []Watcher {
+	c.refreshMutex.Lock()
+	defer c.refreshMutex.Unlock()
+	var ret []Watcher
+	for _, l := range c.layers {
+		if w, ok := l.source.(Watcher); ok && !l.disabled {
+			ret = append(ret, w)
+		}
+	}
+	return ret
+}
+
+func (c *Configuration) watch(ctx context.Context, interval time.Duration, files []string, last fileStats) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	pending := false
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if curr := statFiles(files); !curr.equal(last) {
+			last = curr
+			pending = true
+			continue
+		}
+		if !pending {
+			continue
+		}
+		pending = false
+		_, _ = c.Refresh() // the error has been reported to the subscribers
+		files = c.watchedFiles()
+		last = statFiles(files)
+	}
+}
+
+// watchedFiles returns the files resolved by the last refresh.
+func (c *Configuration) watchedFiles() []string {
+	c.refreshMutex.Lock()
+	defer c.refreshMutex.Unlock()
+	var files []string
+	for _, l := range c.layers {
+		if s, ok := l.source.(*PropertySources); ok && !l.disabled {
+			files = append(files, s.resolved...)
+		}
+	}
+	return files
+}
+
+// fileStat is the part of the file info that indicates a modification.
+type fileStat struct {
+	exist   bool
+	size    int64
+	modTime time.Time
+}
+
+type fileStats map[string]fileStat
+
+func statFiles(files []string) fileStats {
+	m := make(fileStats, len(files))
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			m[file] = fileStat{}
+			continue
+		}
+		m[file] = fileStat{
+			exist:   true,
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		}
+	}
+	return m
+}
+
+func (m fileStats) equal(o fileStats) bool {
+	if len(m) != len(o) {
+		return false
+	}
+	for k, v := range m {
+		w, ok := o[k]
+		if !ok || v.exist != w.exist || v.size != w.size || !v.modTime.Equal(w.modTime) {
+			return false
+		}
+	}
+	return true
+}
@@ -15,9 +15,13 @@
 package conf
 
 import (
+	"encoding"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -35,8 +39,9 @@ type ValidatorInterface interface {
 }
 
 var (
-	ErrNotExist      = errors.New("not exist")
-	ErrInvalidSyntax = errors.New("invalid syntax")
+	ErrNotExist          = errors.New("not exist")
+	ErrInvalidSyntax     = errors.New("invalid syntax")
+	ErrCircularReference = errors.New("circular reference")
 )
 
 // ParsedTag a value tag includes at most three parts: required key, optional
@@ -66,29 +71,29 @@ func (tag ParsedTag) String() string {
 
 // ParseTag parses a value tag, returns its key, and default value, and splitter.
 func ParseTag(tag string) (ret ParsedTag, err error) {
-	i := strings.LastIndex(tag, ">>")
-	if i == 0 {
-		err = fmt.Errorf("parse tag '%s' error: %w", tag, ErrInvalidSyntax)
-		return
-	}
-	j := strings.LastIndex(tag, "}")
-	if j <= 0 {
+	k := strings.Index(tag, "${")
+	if k < 0 {
 		err = fmt.Errorf("parse tag '%s' error: %w", tag, ErrInvalidSyntax)
 		return
 	}
-	k := strings.Index(tag, "${")
-	if k < 0 {
+	j := refEnd(tag, k)
+	if j < 0 {
 		err = fmt.Errorf("parse tag '%s' error: %w", tag, ErrInvalidSyntax)
 		return
 	}
-	if i > j {
-		ret.Splitter = strings.TrimSpace(tag[i+2:])
+	if rest := strings.TrimSpace(tag[j+1:]); rest != "" {
+		if !strings.HasPrefix(rest, ">>") {
+			err = fmt.Errorf("parse tag '%s' error: %w", tag, ErrInvalidSyntax)
+			return
+		}
+		ret.Splitter = strings.TrimSpace(rest[2:])
 	}
-	ss := strings.SplitN(tag[k+2:j], ":=", 2)
-	ret.Key = ss[0]
-	if len(ss) > 1 {
+	body := tag[k+2 : j]
+	ret.Key = body
+	if i := indexTopLevel(body, ":="); i >= 0 {
+		ret.Key = body[:i]
 		ret.HasDef = true
-		ret.Def = ss[1]
+		ret.Def = body[i+2:]
 	}
 	return
 }
@@ -98,6 +103,16 @@ type BindParam struct {
 	Path     string            // full path
 	Tag      ParsedTag         // parsed tag
 	Validate reflect.StructTag // full field tag
+
+	opts   bindOptions   // options of the Bind call
+	target reflect.Value // value bound by a Binder
+}
+
+// bindOptions are the options of a Bind call that pass to all sub params.
+type bindOptions struct {
+	collectErrors bool
+	naming        naming
+	defaults      bool // only for Unbind
 }
 
 func (param *BindParam) BindTag(tag string, validate reflect.StructTag) error {
@@ -125,22 +140,47 @@ type Filter func(i interface{}, param BindParam) (bool, error)
 // BindValue binds properties to a value.
 func BindValue(p *Properties, v reflect.Value, t reflect.Type, param BindParam, filter Filter) (RetErr error) {
 
-	if !IsValueType(t) {
+	plan := getBindPlan(t)
+
+	if plan.refreshable && v.CanAddr() && v.Addr().CanInterface() {
+		r := v.Addr().Interface().(Refreshable)
+		if filter != nil {
+			ret, err := filter(r, param)
+			if err != nil {
+				return err
+			}
+			if ret {
+				return nil
+			}
+		}
+		commit, err := r.OnRefresh(p, param)
+		if err != nil {
+			return err
+		}
+		commit()
+		return nil
+	}
+
+	if !plan.valueType {
 		err := errors.New("target should be value type")
 		return fmt.Errorf("%s: bind %s error, %w", util.FileLine(), param.Path, err)
 	}
 
-	defer func() {
-		if RetErr == nil {
-			tag, ok := param.Validate.Lookup(Validator.Name())
-			if ok && len(tag) > 0 {
-				err := Validator.Field(tag, v.Interface())
-				if err != nil {
-					RetErr = err
-				}
+	if v.Kind() == reflect.Ptr {
+		return bindPtr(p, v, t, param, filter)
+	}
+
+	if Validator != nil && param.Validate != "" {
+		defer func() {
+			if RetErr == nil {
+				RetErr = validate(param, v.Interface())
 			}
-		}
-	}()
+		}()
+	}
+
+	if !plan.converter.IsValid() && plan.unmarshaler && useUnmarshaler(p, v, param) {
+		return bindUnmarshaler(p, v, param)
+	}
 
 	switch v.Kind() {
 	case reflect.Map:
@@ -148,13 +188,22 @@ func BindValue(p *Properties, v reflect.Value, t reflect.Type, param BindParam,
 	case reflect.Slice:
 		return bindSlice(p, v, t, param, filter)
 	case reflect.Array:
-		err := errors.New("use slice instead of array")
-		return fmt.Errorf("%s: bind %s error, %w", util.FileLine(), param.Path, err)
+		return bindArray(p, v, t, param, filter)
+	case reflect.Interface:
+		return bindInterface(p, v, param)
 	default: // for linter
 	}
 
-	fn := converters[t]
-	if fn == nil && v.Kind() == reflect.Struct {
+	if plan.binder && v.CanAddr() {
+		b := v.Addr().Interface().(Binder)
+		param.target = v
+		if err := b.BindFromParam(p, param, filter); err != nil {
+			return fmt.Errorf("%s: bind %s error, %w", util.FileLine(), param.Path, err)
+		}
+		return nil
+	}
+
+	if !plan.converter.IsValid() && v.Kind() == reflect.Struct {
 		if err := bindStruct(p, v, t, param, filter); err != nil {
 			return fmt.Errorf("%s: bind %s error, %w", util.FileLine(), param.Path, err)
 		}
@@ -166,9 +215,8 @@ func BindValue(p *Properties, v reflect.Value, t reflect.Type, param BindParam,
 		return fmt.Errorf("%s: bind %s error, %w", util.FileLine(), param.Path, err)
 	}
 
-	if fn != nil {
-		fnValue := reflect.ValueOf(fn)
-		out := fnValue.Call([]reflect.Value{reflect.ValueOf(val)})
+	if plan.converter.IsValid() {
+		out := plan.converter.Call([]reflect.Value{reflect.ValueOf(val)})
 		if !out[1].IsNil() {
 			err = out[1].Interface().(error)
 			return fmt.Errorf("%s: bind %s error, %w", util.FileLine(), param.Path, err)
@@ -216,6 +264,178 @@ func BindValue(p *Properties, v reflect.Value, t reflect.Type, param BindParam,
 	return fmt.Errorf("%s: bind %s error, %w", util.FileLine(), param.Path, err)
 }
 
+// validate validates the value by the validator tag of the param.
+func validate(param BindParam, i interface{}) error {
+	if Validator == nil {
+		return nil
+	}
+	tag, ok := param.Validate.Lookup(Validator.Name())
+	if ok && len(tag) > 0 {
+		return Validator.Field(tag, i)
+	}
+	return nil
+}
+
+// bindPtr binds properties to a pointer value, it's allocated only when the
+// property or its default value exists, otherwise it's set to nil.
+func bindPtr(p *Properties, v reflect.Value, t reflect.Type, param BindParam, filter Filter) error {
+	if !p.Has(param.Key) && !param.Tag.HasDef {
+		v.Set(reflect.Zero(t))
+		return nil
+	}
+	e := reflect.New(t.Elem())
+	if err := BindValue(p, e.Elem(), t.Elem(), param, filter); err != nil {
+		return err
+	}
+	v.Set(e)
+	return nil
+}
+
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	flagValueType       = reflect.TypeOf((*flag.Value)(nil)).Elem()
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+// isUnmarshaler returns whether the pointer to `t` implements one of
+// encoding.TextUnmarshaler, flag.Value and json.Unmarshaler.
+func isUnmarshaler(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr || t.Kind() == reflect.Interface {
+		return false
+	}
+	pt := reflect.PointerTo(t)
+	return pt.Implements(textUnmarshalerType) ||
+		pt.Implements(flagValueType) ||
+		pt.Implements(jsonUnmarshalerType)
+}
+
+// useUnmarshaler returns whether a value is bound by its unmarshaler, a
+// struct, map, slice or array is bound by it only when the key holds a simple
+// value, calls a function or has a non-empty default value, otherwise it's
+// bound from the sub keys.
+func useUnmarshaler(p *Properties, v reflect.Value, param BindParam) bool {
+	switch v.Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+	default:
+		return true
+	}
+	if _, ok := p.storage.Get(param.Key); ok {
+		return true
+	}
+	if p.storage.Has(param.Key) {
+		return false
+	}
+	if c, ok := parseResolverCall(param.Tag.Key); ok && !c.bare {
+		return true
+	}
+	return param.Tag.HasDef && param.Tag.Def != ""
+}
+
+// bindUnmarshaler binds properties to a value whose pointer implements
+// encoding.TextUnmarshaler, flag.Value or json.Unmarshaler, in that order.
+// The value is passed to json.Unmarshaler as is when it's valid json,
+// otherwise it's quoted as a json string.
+func bindUnmarshaler(p *Properties, v reflect.Value, param BindParam) error {
+
+	val, err := resolve(p, param)
+	if err != nil {
+		return fmt.Errorf("%s: bind %s error, %w", util.FileLine(), param.Path, err)
+	}
+
+	e := reflect.New(v.Type())
+	switch u := e.Interface().(type) {
+	case encoding.TextUnmarshaler:
+		err = u.UnmarshalText([]byte(val))
+	case flag.Value:
+		err = u.Set(val)
+	case json.Unmarshaler:
+		b := []byte(val)
+		if !json.Valid(b) {
+			b, _ = json.Marshal(val)
+		}
+		err = u.UnmarshalJSON(b)
+	default: // for linter
+	}
+	if err != nil {
+		return fmt.Errorf("%s: bind %s error, %w", util.FileLine(), param.Path, err)
+	}
+	v.Set(e.Elem())
+	return nil
+}
+
+// bindInterface binds properties to an empty interface value, it's set to a
+// string, a []interface{} or a map[string]interface{} according to the
+// property, or nil when the property doesn't exist.
+func bindInterface(p *Properties, v reflect.Value, param BindParam) error {
+	if !p.Has(param.Key) && !param.Tag.HasDef {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	if _, ok := p.storage.Get(param.Key); ok || !p.Has(param.Key) {
+		val, err := resolve(p, param)
+		if err != nil {
+			return fmt.Errorf("%s: bind %s error, %w", util.FileLine(), param.Path, err)
+		}
+		v.Set(reflect.ValueOf(val))
+		return nil
+	}
+	val, err := resolveTree(p, p.storage.SubTree(param.Key))
+	if err != nil {
+		return fmt.Errorf("%s: bind %s error, %w", util.FileLine(), param.Path, err)
+	}
+	if val == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	v.Set(reflect.ValueOf(val))
+	return nil
+}
+
+// resolveTree resolves all the string values in the nested structure.
+func resolveTree(p *Properties, v interface{}) (interface{}, error) {
+	switch e := v.(type) {
+	case string:
+		return resolveString(p, e)
+	case map[string]interface{}:
+		for k, x := range e {
+			r, err := resolveTree(p, x)
+			if err != nil {
+				return nil, err
+			}
+			e[k] = r
+		}
+		return e, nil
+	case []interface{}:
+		for i, x := range e {
+			r, err := resolveTree(p, x)
+			if err != nil {
+				return nil, err
+			}
+			e[i] = r
+		}
+		return e, nil
+	default:
+		return v, nil
+	}
+}
+
+// bindArray binds properties to an array value, the number of elements must
+// be equal to the length of the array, unless there is no element at all.
+func bindArray(p *Properties, v reflect.Value, t reflect.Type, param BindParam, filter Filter) error {
+	slice := reflect.New(reflect.SliceOf(t.Elem())).Elem()
+	if err := bindSlice(p, slice, slice.Type(), param, filter); err != nil {
+		return err
+	}
+	if n := slice.Len(); n > 0 && n != t.Len() {
+		err := fmt.Errorf("array length %d doesn't match %d elements", t.Len(), n)
+		return fmt.Errorf("%s: bind %s error, %w", util.FileLine(), param.Path, err)
+	}
+	ret := reflect.New(t).Elem()
+	reflect.Copy(ret, slice)
+	v.Set(ret)
+	return nil
+}
+
 // bindSlice binds properties to a slice value.
 func bindSlice(p *Properties, v reflect.Value, t reflect.Type, param BindParam, filter Filter) error {
 
@@ -232,22 +452,28 @@ func bindSlice(p *Properties, v reflect.Value, t reflect.Type, param BindParam,
 		return nil
 	}
 
+	var errs []*BindError
 	for i := 0; ; i++ {
-		e := reflect.New(et).Elem()
 		subParam := BindParam{
 			Key:  fmt.Sprintf("%s[%d]", param.Key, i),
 			Path: fmt.Sprintf("%s[%d]", param.Path, i),
+			opts: param.opts,
 		}
-		err = BindValue(p, e, et, subParam, filter)
-		if errors.Is(err, ErrNotExist) {
+		if !p.Has(subParam.Key) {
 			break
 		}
+		e := reflect.New(et).Elem()
+		err = BindValue(p, e, et, subParam, filter)
 		if err != nil {
+			if param.opts.collectErrors {
+				errs = collectError(errs, err, subParam)
+				continue
+			}
 			return fmt.Errorf("%s: bind %s error, %w", util.FileLine(), param.Path, err)
 		}
 		slice = reflect.Append(slice, e)
 	}
-	return nil
+	return bindErrors(errs)
 }
 
 func getSlice(p *Properties, et reflect.Type, param BindParam) (*Properties, error) {
@@ -269,7 +495,10 @@ func getSlice(p *Properties, et reflect.Type, param BindParam) (*Properties, err
 			if param.Tag.Def == "" {
 				return nil, nil
 			}
-			if !IsPrimitiveValueType(et) && converters[et] == nil {
+			if et.Kind() == reflect.Ptr {
+				et = et.Elem()
+			}
+			if !IsPrimitiveValueType(et) && converters[et] == nil && !isUnmarshaler(et) {
 				return nil, fmt.Errorf("%s: can't find converter for %s", util.FileLine(), et.String())
 			}
 			strVal = param.Tag.Def
@@ -322,6 +551,7 @@ func bindMap(p *Properties, v reflect.Value, t reflect.Type, param BindParam, fi
 		return fmt.Errorf("%s: bind %s error, %w", util.FileLine(), param.Path, err)
 	}
 
+	var errs []*BindError
 	for _, key := range keys {
 		e := reflect.New(et).Elem()
 		subKey := key
@@ -331,14 +561,19 @@ func bindMap(p *Properties, v reflect.Value, t reflect.Type, param BindParam, fi
 		subParam := BindParam{
 			Key:  subKey,
 			Path: param.Path,
+			opts: param.opts,
 		}
 		err = BindValue(p, e, et, subParam, filter)
 		if err != nil {
+			if param.opts.collectErrors {
+				errs = collectError(errs, err, subParam)
+				continue
+			}
 			return fmt.Errorf("%s: bind %s error, %w", util.FileLine(), param.Path, err)
 		}
 		ret.SetMapIndex(reflect.ValueOf(key), e)
 	}
-	return nil
+	return bindErrors(errs)
 }
 
 // bindStruct binds properties to a struct value.
@@ -349,131 +584,161 @@ func bindStruct(p *Properties, v reflect.Value, t reflect.Type, param BindParam,
 		return fmt.Errorf("%s: bind %s error, %w", util.FileLine(), param.Path, err)
 	}
 
-	for i := 0; i < t.NumField(); i++ {
-		ft := t.Field(i)
-		fv := v.Field(i)
-
-		if !fv.CanInterface() {
+	var errs []*BindError
+	plan := getBindPlan(t)
+	opts := plan.structOptions(param.opts)
+	for i := range plan.fields {
+		f := &plan.fields[i]
+		if f.kind == fieldSkipped {
 			continue
 		}
 
-		subParam := BindParam{
-			Key:  param.Key,
-			Path: param.Path + "." + ft.Name,
+		key, err := f.namedFieldKey(p, param.Key, opts.naming)
+		if err != nil {
+			return fmt.Errorf("%s: bind %s error, %w", util.FileLine(), param.Path, err)
 		}
 
-		if tag, ok := ft.Tag.Lookup("value"); ok {
-			if err := subParam.BindTag(tag, ft.Tag); err != nil {
-				return fmt.Errorf("%s: bind %s error, %w", util.FileLine(), param.Path, err)
-			}
-			if filter != nil {
-				ret, err := filter(fv.Addr().Interface(), subParam)
-				if err != nil {
-					return err
-				}
-				if ret {
-					continue
-				}
-			}
-			if err := BindValue(p, fv, ft.Type, subParam, filter); err != nil {
-				return fmt.Errorf("%s: bind %s error, %w", util.FileLine(), param.Path, err)
-			}
-			continue
+		subParam := BindParam{
+			Key:  key,
+			Path: param.Path + f.path,
+			opts: opts,
 		}
 
-		if ft.Anonymous {
-			// embed pointer type may lead to infinite recursion.
-			if ft.Type.Kind() != reflect.Struct {
+		if err := bindField(p, v.Field(f.index), f, &subParam, filter); err != nil {
+			if param.opts.collectErrors {
+				errs = collectError(errs, err, subParam)
 				continue
 			}
-			if err := bindStruct(p, fv, ft.Type, subParam, filter); err != nil {
-				return fmt.Errorf("%s: bind %s error, %w", util.FileLine(), param.Path, err)
-			}
-			continue
+			return fmt.Errorf("%s: bind %s error, %w", util.FileLine(), param.Path, err)
 		}
+	}
+	return bindErrors(errs)
+}
 
-		if IsValueType(ft.Type) {
-			if subParam.Key == "" {
-				subParam.Key = ft.Name
-			} else {
-				subParam.Key = subParam.Key + "." + ft.Name
+// bindField binds properties to a field of struct.
+func bindField(p *Properties, fv reflect.Value, f *fieldPlan, param *BindParam, filter Filter) error {
+	switch f.kind {
+	case fieldTagged:
+		if f.tagErr != nil {
+			return f.tagErr
+		}
+		param.Tag = f.tag
+		param.Validate = f.validate
+		if filter != nil {
+			ret, err := filter(fv.Addr().Interface(), *param)
+			if err != nil {
+				return err
 			}
-			subParam.Key = strings.ToLower(subParam.Key)
-			subParam.Key = strings.ReplaceAll(subParam.Key, "_", ".")
-			if err := BindValue(p, fv, ft.Type, subParam, filter); err != nil {
-				return fmt.Errorf("%s: bind %s error, %w", util.FileLine(), param.Path, err)
+			if ret {
+				return nil
 			}
 		}
+		return BindValue(p, fv, f.typ, *param, filter)
+	case fieldEmbedded:
+		return bindStruct(p, fv, f.typ, *param, filter)
+	case fieldImplicit:
+		return BindValue(p, fv, f.typ, *param, filter)
+	default:
+		return nil
 	}
-	return nil
 }
 
 // resolve returns property references processed property value.
 func resolve(p *Properties, param BindParam) (string, error) {
+	return resolveRef(p, param, nil)
+}
+
+// resolveRef resolves the reference of the param, chain is the keys being
+// resolved, which is used to detect the circular references.
+func resolveRef(p *Properties, param BindParam, chain []string) (string, error) {
+	c, isCall := parseResolverCall(param.Tag.Key)
+	if isCall && !c.bare {
+		return callResolverFunc(p, c, param, chain)
+	}
 	if val, ok := p.storage.Get(param.Key); ok {
-		return resolveString(p, val)
+		chain, err := pushRef(chain, param.Key)
+		if err != nil {
+			return "", fmt.Errorf("%s: resolve property %q error, %w", util.FileLine(), param.Key, err)
+		}
+		return resolveStringRef(p, val, chain)
 	}
 	if p.storage.Has(param.Key) {
 		err := fmt.Errorf("property %q isn't simple value", param.Key)
 		return "", fmt.Errorf("%s: resolve property %q error, %w", util.FileLine(), param.Key, err)
 	}
+	if isCall {
+		// a bare name is a call only when the function takes no argument,
+		// otherwise it's a missing property.
+		if s, err := c.fn(); err == nil {
+			return s, nil
+		}
+	}
 	if param.Tag.HasDef {
-		return resolveString(p, param.Tag.Def)
+		return resolveStringRef(p, param.Tag.Def, chain)
 	}
 	err := fmt.Errorf("property %q %w", param.Key, ErrNotExist)
 	return "", fmt.Errorf("%s: resolve property %q error, %w", util.FileLine(), param.Key, err)
 }
 
-// resolveString returns property references processed string.
-func resolveString(p *Properties, s string) (string, error) {
+// maxResolveDepth is the max length of a chain of references.
+var maxResolveDepth = 64
 
-	var (
-		length = len(s)
-		count  = 0
-		start  = -1
-		end    = -1
-	)
+// SetMaxResolveDepth sets the max length of a chain of references, such as
+// `a -> b -> c`, default 64, it panics when the depth is less than 1.
+func SetMaxResolveDepth(depth int) {
+	if depth < 1 {
+		panic(errors.New("max resolve depth should be positive"))
+	}
+	maxResolveDepth = depth
+}
 
-	for i := 0; i < length; i++ {
-		if s[i] == '$' {
-			if i < length-1 && s[i+1] == '{' {
-				if count == 0 {
-					start = i
-				}
-				count++
-			}
-		} else if s[i] == '}' {
-			if count > 0 {
-				count--
-				if count == 0 {
-					end = i
-					break
-				}
-			}
-		}
+// pushRef returns a new chain with the key appended, it fails when the key
+// is already in the chain or the chain is too long.
+func pushRef(chain []string, key string) ([]string, error) {
+	next := append(slices.Clip(chain), key)
+	if slices.Contains(chain, key) {
+		return nil, fmt.Errorf("%w %s", ErrCircularReference, strings.Join(next, " -> "))
+	}
+	if len(next) > maxResolveDepth {
+		return nil, fmt.Errorf("reference depth exceeds %d, %s", maxResolveDepth, strings.Join(next, " -> "))
 	}
+	return next, nil
+}
 
-	if start < 0 {
-		return s, nil
+// resolveString returns property references processed string, the escaped
+// "$${" is unescaped, and a value in the form of ENC(...) is decrypted.
+func resolveString(p *Properties, s string) (string, error) {
+	return resolveStringRef(p, s, nil)
+}
+
+// resolveStringRef is resolveString in the chain of references.
+func resolveStringRef(p *Properties, s string, chain []string) (string, error) {
+
+	if ciphertext, ok := isEncrypted(s); ok {
+		return decrypt(p, ciphertext)
 	}
 
-	if end < 0 || count > 0 {
+	text, ref, rest, ok := scanRef(s)
+	if !ok {
 		err := ErrInvalidSyntax
 		return "", fmt.Errorf("%s: resolve string %q error, %w", util.FileLine(), s, err)
 	}
+	if ref == "" {
+		return text, nil
+	}
 
 	var param BindParam
-	_ = param.BindTag(s[start:end+1], "")
+	_ = param.BindTag(ref, "")
 
-	s1, err := resolve(p, param)
+	s1, err := resolveRef(p, param, chain)
 	if err != nil {
 		return "", fmt.Errorf("%s: resolve string %q error, %w", util.FileLine(), s, err)
 	}
 
-	s2, err := resolveString(p, s[end+1:])
+	s2, err := resolveStringRef(p, rest, chain)
 	if err != nil {
 		return "", fmt.Errorf("%s: resolve string %q error, %w", util.FileLine(), s, err)
 	}
 
-	return s[:start] + s1 + s2, nil
+	return text + s1 + s2, nil
 }
@@ -0,0 +1,82 @@
+// Copyright 2024 github.com/lvan100
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"fmt"
+	"testing"
+)
+
+type benchServer struct {
+	Host     string            `value:"${host:=localhost}"`
+	Port     int               `value:"${port:=8080}"`
+	Enabled  bool              `value:"${enabled:=true}"`
+	Ratio    float64           `value:"${ratio:=0.5}"`
+	Tags     []string          `value:"${tags:=a,b,c}"`
+	Labels   map[string]string `value:"${labels:=}"`
+	Max_Conn int
+}
+
+type benchConfig struct {
+	Name    string                 `value:"${name}"`
+	Servers []benchServer          `value:"${servers}"`
+	Backup  benchServer            `value:"${backup}"`
+	Extra   map[string]benchServer `value:"${extra:=}"`
+}
+
+func benchProperties(b *testing.B) *Properties {
+	p := New()
+	_ = p.Set("name", "bench")
+	for i := 0; i < 20; i++ {
+		_ = p.Set(fmt.Sprintf("servers[%d].host", i), fmt.Sprintf("host-%d", i))
+		_ = p.Set(fmt.Sprintf("servers[%d].port", i), "9090")
+		_ = p.Set(fmt.Sprintf("servers[%d].max.conn", i), "100")
+	}
+	_ = p.Set("backup.host", "backup")
+	_ = p.Set("backup.max.conn", "10")
+	var c benchConfig
+	if err := p.Bind(&c); err != nil {
+		b.Fatal(err)
+	}
+	return p
+}
+
+// BenchmarkBind compares binding with the cached plans and binding that
+// compiles the plans for every value, like Bind did before caching.
+func BenchmarkBind(b *testing.B) {
+	p := benchProperties(b)
+
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var c benchConfig
+			if err := p.Bind(&c); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("uncached", func(b *testing.B) {
+		noPlanCache = true
+		defer func() { noPlanCache = false }()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var c benchConfig
+			if err := p.Bind(&c); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
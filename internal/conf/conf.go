@@ -17,18 +17,23 @@ package conf
 import (
 	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
 	"reflect"
+	"slices"
 
 	"github.com/lvan100/go-conf/internal/conf/store"
 	"github.com/lvan100/go-conf/internal/flat"
 )
 
 var (
-	readers    = map[string]Reader{}
-	splitters  = map[string]Splitter{}
-	converters = map[reflect.Type]Converter{}
+	readers     = map[string]Reader{}
+	docReaders  = map[string]DocumentsReader{}
+	lineReaders = map[string]LineReader{}
+	writers     = map[string]Writer{}
+	splitters   = map[string]Splitter{}
+	converters  = map[reflect.Type]Converter{}
 )
 
 // Reader parses []byte into nested map[string]interface{}.
@@ -41,6 +46,47 @@ func RegisterReader(r Reader, ext ...string) {
 	}
 }
 
+// HasReader returns whether there is a Reader for the file extension.
+func HasReader(ext string) bool {
+	_, ok := readers[ext]
+	return ok
+}
+
+// DocumentsReader parses []byte that contains multiple documents, such as
+// yaml documents separated by "---", into nested maps.
+type DocumentsReader func(b []byte) ([]map[string]interface{}, error)
+
+// RegisterDocumentsReader registers its DocumentsReader for some kind of file
+// extension.
+func RegisterDocumentsReader(r DocumentsReader, ext ...string) {
+	for _, s := range ext {
+		docReaders[s] = r
+	}
+}
+
+// LineReader returns the line numbers of the keys in []byte, the keys are
+// flattened in the same way as the properties, such as `a.b` and `a[0].b`.
+type LineReader func(b []byte) (map[string]int, error)
+
+// RegisterLineReader registers its LineReader for some kind of file
+// extension, the origins of the values loaded from the files of the type
+// record their line numbers.
+func RegisterLineReader(r LineReader, ext ...string) {
+	for _, s := range ext {
+		lineReaders[s] = r
+	}
+}
+
+// Writer serializes nested map[string]interface{} into []byte.
+type Writer func(m map[string]interface{}) ([]byte, error)
+
+// RegisterWriter registers its Writer for some kind of file extension.
+func RegisterWriter(w Writer, ext ...string) {
+	for _, s := range ext {
+		writers[s] = w
+	}
+}
+
 // Splitter splits string into []string by some characters.
 type Splitter func(string) ([]string, error)
 
@@ -61,10 +107,12 @@ func RegisterConverter(fn Converter) {
 		panic(errors.New("converter is func(string)(type,error)"))
 	}
 	converters[t.Out(0)] = fn
+	resetBindPlans()
 }
 
-// Properties stores the data with map[string]string and the keys are case-sensitive,
-// you can get one of them by its key, or bind some of them to a value.
+// Properties stores the data with map[string]string and the keys are case-sensitive
+// unless EnableCanonicalKeys is called, you can get one of them by its key, or bind
+// some of them to a value.
 // There are too many formats of configuration files, and too many conflicts between
 // them. Each format of configuration file provides its special characteristics, but
 // usually they are not all necessary, and complementary. For example, `conf` disabled
@@ -77,12 +125,17 @@ func RegisterConverter(fn Converter) {
 // by node. So `conf` uses a tree to strictly verify and a flat map to store.
 type Properties struct {
 	storage *store.Storage
+	origins map[string][]Origin
+	origin  Origin // origin of the values set through this *Properties
+	secrets *secretSet
 }
 
 // New creates empty *Properties.
 func New() *Properties {
 	return &Properties{
 		storage: store.NewStorage(),
+		origins: make(map[string][]Origin),
+		secrets: newSecretSet(),
 	}
 }
 
@@ -95,6 +148,40 @@ func Load(file string) (*Properties, error) {
 	return p, nil
 }
 
+// LoadDocuments creates a *Properties for each document of the file, the
+// file has only one document when there is no DocumentsReader for its type.
+func LoadDocuments(file string) ([]*Properties, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	ext := filepath.Ext(file)
+	r, ok := docReaders[ext]
+	if !ok {
+		p := New()
+		if err = p.Bytes(b, ext); err != nil {
+			return nil, err
+		}
+		if err = p.readLines(b, ext, file); err != nil {
+			return nil, err
+		}
+		return []*Properties{p}, nil
+	}
+	docs, err := r(b)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*Properties, 0, len(docs))
+	for _, m := range docs {
+		p := New()
+		if err = p.Merge(m); err != nil {
+			return nil, err
+		}
+		ret = append(ret, p)
+	}
+	return ret, nil
+}
+
 // Load loads properties from file.
 func (p *Properties) Load(file string) error {
 	b, err := os.ReadFile(file)
@@ -117,6 +204,32 @@ func (p *Properties) Bytes(b []byte, ext string) error {
 	return p.Merge(m)
 }
 
+// Save saves properties into file, the format is decided by the file name
+// extension.
+func (p *Properties) Save(file string) error {
+	b, err := p.Marshal(filepath.Ext(file))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, b, 0644)
+}
+
+// Marshal serializes properties into []byte, ext is the file name extension.
+func (p *Properties) Marshal(ext string) ([]byte, error) {
+	w, ok := writers[ext]
+	if !ok {
+		return nil, fmt.Errorf("unsupported file type %q", ext)
+	}
+	return w(p.Tree())
+}
+
+// Tree returns the nested structure of the properties, the arrays become
+// []interface{}, the maps become map[string]interface{}, the values become
+// string, and the empty values, maps and arrays become nil.
+func (p *Properties) Tree() map[string]interface{} {
+	return p.storage.Tree()
+}
+
 // Merge flattens the map and sets all keys and values.
 func (p *Properties) Merge(m map[string]interface{}) error {
 	s := flat.FlattenMap(m)
@@ -133,7 +246,52 @@ func (p *Properties) merge(m map[string]string) error {
 }
 
 func (p *Properties) store(key, val string) error {
-	return p.storage.Set(key, val)
+	if err := p.storage.Set(key, val); err != nil {
+		return err
+	}
+	if p.origin.Layer != "" || p.origin.Source != "" {
+		o := p.origin
+		o.Value = val
+		key = p.storage.Key(key)
+		p.origins[key] = append(p.origins[key], o)
+	}
+	return nil
+}
+
+type Collision = store.Collision
+
+// EnableCanonicalKeys makes the keys case-insensitive and ignore '-' and '_'
+// on both Set and Get/Bind, such as `Server.Max-Conns`, `server.max_conns`
+// and `SERVER_MAXCONNS` are the same key `server.maxconns`. The keys are
+// stored in the canonical form, and the existing keys are converted. When
+// two different spellings of a key are merged, the later one overrides the
+// earlier one, and a Collision is recorded.
+func (p *Properties) EnableCanonicalKeys() error {
+	if p.storage.Canonical() {
+		return nil
+	}
+	if err := p.storage.Canonicalize(); err != nil {
+		return err
+	}
+	origins := make(map[string][]Origin, len(p.origins))
+	for _, key := range store.OrderedMapKeys(p.origins) {
+		k := p.storage.Key(key)
+		origins[k] = append(origins[k], p.origins[key]...)
+	}
+	clear(p.origins)
+	maps.Copy(p.origins, origins)
+	return nil
+}
+
+// CanonicalKeys returns whether the keys are canonical.
+func (p *Properties) CanonicalKeys() bool {
+	return p.storage.Canonical()
+}
+
+// Collisions returns the collisions of the keys in order, it's always empty
+// when the keys aren't canonical.
+func (p *Properties) Collisions() []Collision {
+	return p.storage.Collisions()
 }
 
 func (p *Properties) Data() map[string]string {
@@ -141,9 +299,72 @@ func (p *Properties) Data() map[string]string {
 }
 
 func (p *Properties) Copy() *Properties {
+	origins := make(map[string][]Origin, len(p.origins))
+	for k, v := range p.origins {
+		origins[k] = slices.Clone(v)
+	}
 	return &Properties{
 		storage: p.storage.Copy(),
+		origins: origins,
+		secrets: p.secrets.copy(),
+	}
+}
+
+// CopyTo copies all the properties to the output, so that *Properties can be
+// used as a source of Configuration.
+func (p *Properties) CopyTo(out *Properties) error {
+	for _, key := range p.Keys() {
+		if err := out.Set(key, p.Get(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type (
+	Change     = store.Change
+	ChangeType = store.ChangeType
+)
+
+const (
+	ChangeAdded    = store.ChangeAdded
+	ChangeRemoved  = store.ChangeRemoved
+	ChangeModified = store.ChangeModified
+)
+
+// Diff returns the changes of keys from old to new sorted by key, a nil
+// *Properties is treated as an empty one, the secret values are redacted.
+func Diff(old, new *Properties) []Change {
+	var o, n *store.Storage
+	if old != nil {
+		o = old.storage
 	}
+	if new != nil {
+		n = new.storage
+	}
+	changes := store.Diff(o, n)
+	for i := range changes {
+		c := &changes[i]
+		if IsSecretValue(c.Old) || IsSecretValue(c.New) {
+			c.Old, c.New = redactValue(c.Old), redactValue(c.New)
+			continue
+		}
+		if old != nil {
+			c.Old = old.Redact(c.Old)
+		}
+		if new != nil {
+			c.New = new.Redact(c.New)
+		}
+	}
+	return changes
+}
+
+// redactValue returns Redacted for a non-empty value.
+func redactValue(s string) string {
+	if s == "" {
+		return ""
+	}
+	return Redacted
 }
 
 // Keys returns all sorted keys.
@@ -200,7 +421,8 @@ func (p *Properties) Set(key string, val interface{}) error {
 // Resolve resolves string value that contains references to other
 // properties, the references are defined by ${key:=def}.
 func (p *Properties) Resolve(s string) (string, error) {
-	return resolveString(p, s)
+	s, err := resolveString(p, s)
+	return s, p.RedactError(err)
 }
 
 type BindArg interface {
@@ -228,6 +450,69 @@ func (tag tagArg) getParam() (BindParam, error) {
 	return param, nil
 }
 
+// optionArg is a BindArg that changes the behavior of the Bind call.
+type optionArg func(opts *bindOptions)
+
+func (o optionArg) getParam() (BindParam, error) {
+	return BindParam{}, errors.New("bind option isn't a param")
+}
+
+// CollectErrors makes Bind continue across all fields when errors occur,
+// and return all the errors as a *BindErrors.
+func CollectErrors() BindArg {
+	return optionArg(func(opts *bindOptions) {
+		opts.collectErrors = true
+	})
+}
+
+// Defaults makes Unbind write the default value of the tag instead of the
+// zero value of a field, so that an empty struct generates its default
+// configuration. A zero struct with an empty default writes its fields.
+func Defaults() BindArg {
+	return optionArg(func(opts *bindOptions) {
+		opts.defaults = true
+	})
+}
+
+// Naming selects the naming of the fields without value tag, the spec is a
+// registered NamingStrategy, such as "kebab", with an optional ",relaxed".
+// The marker field of a struct overrides it for the struct and its fields.
+func Naming(spec string) BindArg {
+	n := parseNaming(spec)
+	return optionArg(func(opts *bindOptions) {
+		opts.naming = n
+	})
+}
+
+// getBindParam returns the param of the first non-option BindArg, or the
+// ROOT param when there isn't one, with all the options applied.
+func getBindParam(args []BindArg) (BindParam, error) {
+	var (
+		opts  bindOptions
+		param BindParam
+		found bool
+	)
+	for _, arg := range args {
+		if o, ok := arg.(optionArg); ok {
+			o(&opts)
+			continue
+		}
+		if found {
+			continue
+		}
+		var err error
+		if param, err = arg.getParam(); err != nil {
+			return BindParam{}, err
+		}
+		found = true
+	}
+	if !found {
+		_ = param.BindTag("${ROOT}", "")
+	}
+	param.opts = opts
+	return param, nil
+}
+
 // Key binds properties using one key.
 func Key(key string) BindArg {
 	return tagArg{tag: "${" + key + "}"}
@@ -250,6 +535,12 @@ func Param(param BindParam) BindArg {
 // 'splitter' is the Splitter's name when you want split string value
 // into []string value.
 func (p *Properties) Bind(i interface{}, args ...BindArg) error {
+	return p.BindWithFilter(i, nil, args...)
+}
+
+// BindWithFilter binds properties to a value like Bind, and the filter is
+// called before binding each field, the field is skipped when it returns true.
+func (p *Properties) BindWithFilter(i interface{}, filter Filter, args ...BindArg) error {
 
 	var v reflect.Value
 	{
@@ -265,20 +556,24 @@ func (p *Properties) Bind(i interface{}, args ...BindArg) error {
 		}
 	}
 
-	if len(args) == 0 {
-		args = []BindArg{tagArg{tag: "${ROOT}"}}
-	}
-
 	t := v.Type()
 	typeName := t.Name()
 	if typeName == "" { // primitive type has no name
 		typeName = t.String()
 	}
 
-	param, err := args[0].getParam()
+	param, err := getBindParam(args)
 	if err != nil {
 		return err
 	}
 	param.Path = typeName
-	return BindValue(p, v, t, param, nil)
+	err = BindValue(p, v, t, param, filter)
+	if err != nil && param.opts.collectErrors {
+		var e *BindErrors
+		if !errors.As(err, &e) {
+			e = &BindErrors{Errors: []*BindError{{Path: param.Path, Key: param.Key, Err: err}}}
+		}
+		return p.RedactError(e)
+	}
+	return p.RedactError(err)
 }
@@ -0,0 +1,53 @@
+// Copyright 2024 github.com/lvan100
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"reflect"
+	"sync/atomic"
+)
+
+// Refreshable is a value that can be bound again when properties refresh.
+type Refreshable interface {
+	// OnRefresh binds and validates a new value, the new value doesn't take
+	// effect until the returned commit function is called.
+	OnRefresh(p *Properties, param BindParam) (commit func(), err error)
+}
+
+// Dynamic is a value which is updated in place when properties refresh, it
+// can be read in other goroutines safely.
+type Dynamic[T any] struct {
+	v atomic.Pointer[T]
+}
+
+// Value returns the current value.
+func (d *Dynamic[T]) Value() T {
+	if p := d.v.Load(); p != nil {
+		return *p
+	}
+	var zero T
+	return zero
+}
+
+// OnRefresh binds and validates a new value, and swaps the current value
+// with it when commit.
+func (d *Dynamic[T]) OnRefresh(p *Properties, param BindParam) (func(), error) {
+	var t T
+	v := reflect.ValueOf(&t).Elem()
+	if err := BindValue(p, v, v.Type(), param, nil); err != nil {
+		return nil, err
+	}
+	return func() { d.v.Store(&t) }, nil
+}
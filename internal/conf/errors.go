@@ -0,0 +1,78 @@
+// Copyright 2024 github.com/lvan100
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// BindError is the error of binding one field.
+type BindError struct {
+	Path string // full path of the field
+	Key  string // full key of the property
+	Err  error
+}
+
+func (e *BindError) Error() string {
+	return fmt.Sprintf("bind %s (key %q) error, %v", e.Path, e.Key, e.Err)
+}
+
+func (e *BindError) Unwrap() error {
+	return e.Err
+}
+
+// BindErrors collects all the errors of a Bind call when CollectErrors is
+// used, it supports errors.Is and errors.As on each of the errors.
+type BindErrors struct {
+	Errors []*BindError
+}
+
+func (e *BindErrors) Error() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%d bind errors:", len(e.Errors)))
+	for _, err := range e.Errors {
+		sb.WriteString("\n\t")
+		sb.WriteString(err.Error())
+	}
+	return sb.String()
+}
+
+func (e *BindErrors) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+// collectError appends the error of binding a field, the errors collected
+// from its sub fields are flattened.
+func collectError(errs []*BindError, err error, param BindParam) []*BindError {
+	var e *BindErrors
+	if errors.As(err, &e) {
+		return append(errs, e.Errors...)
+	}
+	return append(errs, &BindError{Path: param.Path, Key: param.Key, Err: err})
+}
+
+// bindErrors returns nil when there is no error.
+func bindErrors(errs []*BindError) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return &BindErrors{Errors: errs}
+}
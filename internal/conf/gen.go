@@ -0,0 +1,258 @@
+// Copyright 2024 github.com/lvan100
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/lvan100/go-conf/internal/util"
+)
+
+// Binder is implemented by the struct types generated by go-conf-gen, Bind
+// prefers it to reflection when the target implements it.
+type Binder interface {
+	BindFromParam(p *Properties, param BindParam, filter Filter) error
+}
+
+// StructBinder binds the fields of a struct in the generated code, it has the
+// same semantics as binding a struct by reflection.
+type StructBinder struct {
+	p      *Properties
+	param  BindParam
+	filter Filter
+	errs   []*BindError
+	err    error
+	done   bool // the struct is bound by reflection
+}
+
+// NewStructBinder returns a StructBinder for the struct that `i` points to.
+// When the Binder method is promoted from an embedded struct, which means
+// the bound struct isn't generated, the bound struct is bound by reflection
+// and all the fields of the embedded struct are skipped.
+func NewStructBinder(i interface{}, p *Properties, param BindParam, filter Filter) StructBinder {
+	target := param.target
+	param.target = reflect.Value{}
+	b := StructBinder{p: p, param: param, filter: filter}
+	if target.IsValid() && reflect.TypeOf(i).Elem() != target.Type() {
+		b.done = true
+		b.err = bindStruct(p, target, target.Type(), param, filter)
+		return b
+	}
+	if param.Tag.HasDef && param.Tag.Def != "" {
+		b.err = errors.New("struct can't have a non-empty default value")
+	}
+	b.param.opts = getBindPlan(reflect.TypeOf(i).Elem()).structOptions(param.opts)
+	return b
+}
+
+// Tagged returns the param of a field with value tag, `i` is the pointer to
+// the field. It returns false when the field should be skipped.
+func (b *StructBinder) Tagged(i interface{}, path string, tag ParsedTag, validate reflect.StructTag) (BindParam, bool) {
+	if b.err != nil {
+		return BindParam{}, false
+	}
+	param := BindParam{
+		Key:      taggedFieldKey(b.param.Key, tag.Key),
+		Path:     b.param.Path + path,
+		Tag:      tag,
+		Validate: validate,
+		opts:     b.param.opts,
+	}
+	if b.filter != nil {
+		ret, err := b.filter(i, param)
+		if err != nil {
+			b.Done(param, err)
+			return BindParam{}, false
+		}
+		if ret {
+			return BindParam{}, false
+		}
+	}
+	return param, true
+}
+
+// Implicit returns the param of a field without value tag, `i` is the pointer
+// to the field, its key is converted from the field name by the naming. It
+// returns false when the field isn't a value type.
+func (b *StructBinder) Implicit(i interface{}, path string, name string) (BindParam, bool) {
+	if b.err != nil || !getBindPlan(reflect.TypeOf(i).Elem()).valueType {
+		return BindParam{}, false
+	}
+	param := BindParam{
+		Path: b.param.Path + path,
+		opts: b.param.opts,
+	}
+	if n := b.param.opts.naming; n.isDefault() {
+		param.Key = implicitFieldKey(b.param.Key, implicitKey(name))
+	} else {
+		key, err := n.fieldKey(b.p, b.param.Key, name)
+		if err != nil {
+			b.err = err
+			return BindParam{}, false
+		}
+		param.Key = key
+	}
+	return param, true
+}
+
+// Embedded returns the param of an embedded struct without value tag.
+func (b *StructBinder) Embedded(path string) (BindParam, bool) {
+	if b.err != nil {
+		return BindParam{}, false
+	}
+	return BindParam{
+		Key:  b.param.Key,
+		Path: b.param.Path + path,
+		opts: b.param.opts,
+	}, true
+}
+
+// Done records the error of binding a field.
+func (b *StructBinder) Done(param BindParam, err error) {
+	if err == nil {
+		return
+	}
+	if b.param.opts.collectErrors {
+		b.errs = collectError(b.errs, err, param)
+		return
+	}
+	b.err = err
+}
+
+// Err returns the error of binding the struct.
+func (b *StructBinder) Err() error {
+	if b.done {
+		return b.err
+	}
+	if b.err != nil {
+		err := fmt.Errorf("%s: bind %s error, %w", util.FileLine(), b.param.Path, b.err)
+		return b.p.RedactError(err)
+	}
+	return b.p.RedactError(bindErrors(b.errs))
+}
+
+// BindAny binds properties to the value that `i` points to, it's used by the
+// generated code for the types that have no fast path.
+func BindAny(p *Properties, i interface{}, param BindParam, filter Filter) error {
+	v := reflect.ValueOf(i).Elem()
+	return BindValue(p, v, v.Type(), param, filter)
+}
+
+// BindEmbedded binds properties to the embedded struct that `i` points to.
+func BindEmbedded(p *Properties, i interface{}, param BindParam, filter Filter) error {
+	v := reflect.ValueOf(i).Elem()
+	if b, ok := i.(Binder); ok {
+		param.target = v
+		return b.BindFromParam(p, param, filter)
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	return bindStruct(p, v, v.Type(), param, filter)
+}
+
+// Convert returns the property value converted to T, the primitive types are
+// parsed without reflection, others are bound by BindValue.
+func Convert[T any](p *Properties, param BindParam) (T, error) {
+	var v T
+
+	t := reflect.TypeFor[T]()
+	if plan := getBindPlan(t); plan.converter.IsValid() || plan.unmarshaler || !isFastType(&v) {
+		err := BindValue(p, reflect.ValueOf(&v).Elem(), t, param, nil)
+		return v, err
+	}
+
+	var (
+		i   int64
+		u   uint64
+		err error
+	)
+
+	val, err := resolve(p, param)
+	if err != nil {
+		return v, fmt.Errorf("%s: bind %s error, %w", util.FileLine(), param.Path, err)
+	}
+
+	switch x := any(&v).(type) {
+	case *string:
+		*x = val
+	case *bool:
+		*x, err = strconv.ParseBool(val)
+	case *int:
+		i, err = strconv.ParseInt(val, 0, 0)
+		*x = int(i)
+	case *int8:
+		i, err = strconv.ParseInt(val, 0, 0)
+		*x = int8(i)
+	case *int16:
+		i, err = strconv.ParseInt(val, 0, 0)
+		*x = int16(i)
+	case *int32:
+		i, err = strconv.ParseInt(val, 0, 0)
+		*x = int32(i)
+	case *int64:
+		*x, err = strconv.ParseInt(val, 0, 0)
+	case *uint:
+		u, err = strconv.ParseUint(val, 0, 0)
+		*x = uint(u)
+	case *uint8:
+		u, err = strconv.ParseUint(val, 0, 0)
+		*x = uint8(u)
+	case *uint16:
+		u, err = strconv.ParseUint(val, 0, 0)
+		*x = uint16(u)
+	case *uint32:
+		u, err = strconv.ParseUint(val, 0, 0)
+		*x = uint32(u)
+	case *uint64:
+		*x, err = strconv.ParseUint(val, 0, 0)
+	case *float32:
+		var f float64
+		f, err = strconv.ParseFloat(val, 64)
+		*x = float32(f)
+	case *float64:
+		*x, err = strconv.ParseFloat(val, 64)
+	default: // for linter
+	}
+
+	if err != nil {
+		var zero T
+		return zero, fmt.Errorf("%s: bind %s error, %w", util.FileLine(), param.Path, err)
+	}
+	if Validator != nil && param.Validate != "" {
+		if err = validate(param, v); err != nil {
+			return v, err
+		}
+	}
+	return v, nil
+}
+
+// isFastType returns whether `i` points to a primitive type that Convert
+// parses without reflection.
+func isFastType(i interface{}) bool {
+	switch i.(type) {
+	case *string, *bool, *float32, *float64:
+		return true
+	case *int, *int8, *int16, *int32, *int64:
+		return true
+	case *uint, *uint8, *uint16, *uint32, *uint64:
+		return true
+	default:
+		return false
+	}
+}
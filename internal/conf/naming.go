@@ -0,0 +1,160 @@
+// Copyright 2024 github.com/lvan100
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// NamingStrategy converts the name of a field without value tag to its key.
+type NamingStrategy func(name string) string
+
+var namings = map[string]NamingStrategy{}
+
+// RegisterNaming registers a NamingStrategy and named it.
+func RegisterNaming(name string, fn NamingStrategy) {
+	namings[name] = fn
+}
+
+func init() {
+	RegisterNaming("lower", implicitKey)
+	RegisterNaming("kebab", func(name string) string {
+		return strings.ToLower(strings.Join(splitWords(name), "-"))
+	})
+	RegisterNaming("snake", func(name string) string {
+		return strings.ToLower(strings.Join(splitWords(name), "_"))
+	})
+	RegisterNaming("camel", func(name string) string {
+		words := splitWords(name)
+		for i, w := range words {
+			w = strings.ToLower(w)
+			if i > 0 {
+				r := []rune(w)
+				r[0] = unicode.ToUpper(r[0])
+				w = string(r)
+			}
+			words[i] = w
+		}
+		return strings.Join(words, "")
+	})
+	RegisterNaming("exact", func(name string) string {
+		return name
+	})
+}
+
+// relaxedNamings are the strategies tried in order in the relaxed mode.
+var relaxedNamings = []string{"lower", "kebab", "snake", "camel", "exact"}
+
+// naming is the naming of the fields without value tag, it's selected by
+// the Naming option of Bind, or by the marker field of a struct:
+//
+//	_ struct{} `naming:"kebab"`
+//
+// The spec is a strategy name, and an optional ",relaxed" which makes the
+// fields match the keys in any of the built-in forms, such as "kebab,relaxed".
+type naming struct {
+	name    string // empty means lower
+	relaxed bool
+}
+
+// parseNaming parses the spec of a naming.
+func parseNaming(spec string) naming {
+	var n naming
+	for _, s := range strings.Split(spec, ",") {
+		if s = strings.TrimSpace(s); s == "relaxed" {
+			n.relaxed = true
+		} else if s != "" {
+			n.name = s
+		}
+	}
+	return n
+}
+
+// isDefault returns whether it's the lower naming without relaxed mode.
+func (n naming) isDefault() bool {
+	return !n.relaxed && (n.name == "" || n.name == "lower")
+}
+
+// fieldKey returns the full key of a field without value tag under the
+// struct key. In the relaxed mode, it returns the first key in the forms
+// that exists, or the key by the strategy when none exists.
+func (n naming) fieldKey(p *Properties, key, name string) (string, error) {
+	primary := n.name
+	if primary == "" {
+		primary = "lower"
+	}
+	fn, ok := namings[primary]
+	if !ok {
+		return "", fmt.Errorf("unknown naming strategy %q", primary)
+	}
+	ret := namingFieldKey(primary, key, fn(name))
+	if !n.relaxed || p == nil || p.Has(ret) {
+		return ret, nil
+	}
+	for _, s := range relaxedNamings {
+		if s == primary {
+			continue
+		}
+		if k := namingFieldKey(s, key, namings[s](name)); p.Has(k) {
+			return k, nil
+		}
+	}
+	return ret, nil
+}
+
+// namingFieldKey joins the struct key and the field key, the struct key is
+// converted by the lower strategy only.
+func namingFieldKey(strategy string, key, sub string) string {
+	if strategy == "lower" {
+		return implicitFieldKey(key, sub)
+	}
+	if key == "" {
+		return sub
+	}
+	return key + "." + sub
+}
+
+// splitWords splits a field name into words, such as MaxIdleConns into
+// Max, Idle, Conns, and HTTPServer into HTTP, Server.
+func splitWords(s string) []string {
+	var (
+		words []string
+		runes = []rune(s)
+		start = 0
+	)
+	for i, r := range runes {
+		if r == '_' || r == '-' || r == '.' {
+			if i > start {
+				words = append(words, string(runes[start:i]))
+			}
+			start = i + 1
+			continue
+		}
+		if i > start && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				words = append(words, string(runes[start:i]))
+				start = i
+			}
+		}
+	}
+	if start < len(runes) {
+		words = append(words, string(runes[start:]))
+	}
+	return words
+}
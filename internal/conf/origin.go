@@ -0,0 +1,124 @@
+// Copyright 2024 github.com/lvan100
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Origin describes where a property value comes from. The line number is
+// recorded for the values read from the files whose type has a LineReader,
+// such as toml, but not for the other types, whose readers parse the files
+// into maps that have no positions.
+type Origin struct {
+	Layer  string // layer name, such as prop, file, env, args, dync
+	Source string // file name, environment variable or command line argument
+	Line   int    // line number in the file, 0 if unknown
+	Value  string // raw value set by this origin
+}
+
+func (o Origin) String() string {
+	if o.Source == "" {
+		return o.Layer
+	}
+	if o.Line > 0 {
+		return o.Layer + ":" + o.Source + ":" + strconv.Itoa(o.Line)
+	}
+	return o.Layer + ":" + o.Source
+}
+
+// WithLayer returns a *Properties sharing data with p, and the values set
+// through it are recorded as coming from the layer.
+func (p *Properties) WithLayer(layer string) *Properties {
+	r := *p
+	r.origin = Origin{Layer: layer}
+	return &r
+}
+
+// WithSource returns a *Properties sharing data with p, and the values set
+// through it are recorded as coming from the source of the current layer.
+func (p *Properties) WithSource(source string) *Properties {
+	r := *p
+	r.origin.Source = source
+	r.origin.Line = 0
+	return &r
+}
+
+// WithLine returns a *Properties sharing data with p, and the values set
+// through it are recorded at the line of the current source.
+func (p *Properties) WithLine(line int) *Properties {
+	r := *p
+	r.origin.Line = line
+	return &r
+}
+
+// readLines records the origins of the keys with their line numbers in the
+// file, when there is a LineReader for the type.
+func (p *Properties) readLines(b []byte, ext, file string) error {
+	r, ok := lineReaders[ext]
+	if !ok {
+		return nil
+	}
+	lines, err := r(b)
+	if err != nil {
+		return err
+	}
+	for _, key := range p.Keys() {
+		if line := lineOf(lines, key); line > 0 {
+			val, _ := p.storage.Get(key)
+			p.origins[key] = []Origin{{Source: file, Line: line, Value: val}}
+		}
+	}
+	return nil
+}
+
+// lineOf returns the line of the key, or of its nearest parent when the key
+// isn't in lines, such as an element of an array.
+func lineOf(lines map[string]int, key string) int {
+	for {
+		if line, ok := lines[key]; ok {
+			return line
+		}
+		i := strings.LastIndexAny(key, ".[")
+		if i <= 0 {
+			return 0
+		}
+		key = key[:i]
+	}
+}
+
+// Origin returns the origin of key's current value, it returns false when
+// the key doesn't exist or its origin isn't recorded.
+func (p *Properties) Origin(key string) (Origin, bool) {
+	if _, ok := p.storage.Get(key); !ok {
+		return Origin{}, false
+	}
+	origins := p.origins[p.storage.Key(key)]
+	if len(origins) == 0 {
+		return Origin{}, false
+	}
+	return origins[len(origins)-1], true
+}
+
+// Origins returns all the recorded origins that set the key in order, the
+// earlier ones are overridden by the later ones.
+func (p *Properties) Origins(key string) []Origin {
+	if _, ok := p.storage.Get(key); !ok {
+		return nil
+	}
+	return slices.Clone(p.origins[p.storage.Key(key)])
+}
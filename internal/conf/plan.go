@@ -0,0 +1,220 @@
+// Copyright 2024 github.com/lvan100
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+var (
+	refreshableType = reflect.TypeOf((*Refreshable)(nil)).Elem()
+	binderType      = reflect.TypeOf((*Binder)(nil)).Elem()
+)
+
+var (
+	// plans caches the bind plan of each type, map[reflect.Type]*bindPlan.
+	plans sync.Map
+
+	// noPlanCache makes every Bind compile the plans, only for benchmarks.
+	noPlanCache bool
+)
+
+// bindPlan is the compiled bind plan of a type, the reflection checks, the
+// converter and the struct tags are computed once and reused by every Bind.
+// The field keys are relative to the struct, so that a plan can be shared
+// by all the root keys which the type is bound to.
+type bindPlan struct {
+	valueType   bool
+	refreshable bool
+	unmarshaler bool
+	binder      bool
+	converter   reflect.Value // invalid if there is no converter
+	fields      []fieldPlan   // only for struct type
+	naming      naming        // naming of the struct marker field
+	hasNaming   bool
+}
+
+type fieldKind int
+
+const (
+	fieldSkipped  fieldKind = iota // unexported or not a value type
+	fieldTagged                    // has a value tag
+	fieldEmbedded                  // embedded struct without value tag
+	fieldImplicit                  // value type without value tag
+)
+
+// fieldPlan is the compiled bind plan of a struct field.
+type fieldPlan struct {
+	index    int
+	kind     fieldKind
+	typ      reflect.Type
+	path     string            // "." + field name
+	key      string            // key relative to the struct
+	name     string            // field name
+	tag      ParsedTag         // normalized value tag
+	tagErr   error             // error of parsing the value tag
+	validate reflect.StructTag // full field tag
+}
+
+// getBindPlan returns the cached bind plan of the type, or compiles one.
+func getBindPlan(t reflect.Type) *bindPlan {
+	if noPlanCache {
+		return compileBindPlan(t)
+	}
+	if v, ok := plans.Load(t); ok {
+		return v.(*bindPlan)
+	}
+	v, _ := plans.LoadOrStore(t, compileBindPlan(t))
+	return v.(*bindPlan)
+}
+
+// resetBindPlans drops all the cached plans, it's called when the converters
+// are changed.
+func resetBindPlans() {
+	plans.Range(func(key, _ interface{}) bool {
+		plans.Delete(key)
+		return true
+	})
+}
+
+func compileBindPlan(t reflect.Type) *bindPlan {
+	plan := &bindPlan{
+		valueType:   IsValueType(t),
+		refreshable: reflect.PointerTo(t).Implements(refreshableType),
+		unmarshaler: isUnmarshaler(t),
+		binder:      reflect.PointerTo(t).Implements(binderType),
+	}
+	if fn := converters[t]; fn != nil {
+		plan.converter = reflect.ValueOf(fn)
+	}
+	if t.Kind() == reflect.Struct {
+		plan.fields = make([]fieldPlan, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			ft := t.Field(i)
+			if spec, ok := ft.Tag.Lookup("naming"); ok && ft.Name == "_" {
+				plan.naming, plan.hasNaming = parseNaming(spec), true
+			}
+			plan.fields[i] = compileFieldPlan(ft)
+		}
+	}
+	return plan
+}
+
+func compileFieldPlan(ft reflect.StructField) fieldPlan {
+	f := fieldPlan{
+		index:    ft.Index[0],
+		typ:      ft.Type,
+		path:     "." + ft.Name,
+		name:     ft.Name,
+		validate: ft.Tag,
+	}
+
+	if !ft.IsExported() {
+		return f
+	}
+
+	if tag, ok := ft.Tag.Lookup("value"); ok {
+		var param BindParam
+		f.kind = fieldTagged
+		f.tagErr = param.BindTag(tag, ft.Tag)
+		f.tag = param.Tag
+		f.key = param.Tag.Key
+		return f
+	}
+
+	if ft.Anonymous {
+		// embed pointer type may lead to infinite recursion.
+		if ft.Type.Kind() == reflect.Struct {
+			f.kind = fieldEmbedded
+		}
+		return f
+	}
+
+	if IsValueType(ft.Type) {
+		f.kind = fieldImplicit
+		f.key = implicitKey(ft.Name)
+	}
+	return f
+}
+
+// implicitKey returns the key of a field without value tag.
+func implicitKey(s string) string {
+	s = strings.ToLower(s)
+	return strings.ReplaceAll(s, "_", ".")
+}
+
+// isImplicitKey returns whether the key is unchanged by implicitKey.
+func isImplicitKey(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c == '_' || (c >= 'A' && c <= 'Z') || c >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// fieldKey returns the full key of the field under the struct key.
+func (f *fieldPlan) fieldKey(key string) string {
+	switch f.kind {
+	case fieldTagged:
+		return taggedFieldKey(key, f.key)
+	case fieldImplicit:
+		return implicitFieldKey(key, f.key)
+	default:
+		return key
+	}
+}
+
+// namedFieldKey returns the full key of the field under the struct key, the
+// key of a field without value tag is converted by the naming.
+func (f *fieldPlan) namedFieldKey(p *Properties, key string, n naming) (string, error) {
+	if f.kind != fieldImplicit || n.isDefault() {
+		return f.fieldKey(key), nil
+	}
+	return n.fieldKey(p, key, f.name)
+}
+
+// structOptions returns the options of binding the fields of the struct, the
+// naming of the struct marker field overrides the one of the Bind call.
+func (plan *bindPlan) structOptions(opts bindOptions) bindOptions {
+	if plan.hasNaming {
+		opts.naming = plan.naming
+	}
+	return opts
+}
+
+// taggedFieldKey returns the full key of a field with value tag.
+func taggedFieldKey(key, sub string) string {
+	if key == "" {
+		return sub
+	} else if sub != "" {
+		return key + "." + sub
+	}
+	return key
+}
+
+// implicitFieldKey returns the full key of a field without value tag.
+func implicitFieldKey(key, sub string) string {
+	if key == "" {
+		return sub
+	}
+	if !isImplicitKey(key) {
+		key = implicitKey(key)
+	}
+	return key + "." + sub
+}
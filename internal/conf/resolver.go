@@ -0,0 +1,211 @@
+// Copyright 2024 github.com/lvan100
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lvan100/go-conf/internal/util"
+)
+
+// ResolverFunc is a function called in references, its arguments have been
+// resolved. It returns an error wrapping ErrNotExist to use the default value.
+type ResolverFunc func(args ...string) (string, error)
+
+var resolverFuncs = map[string]ResolverFunc{}
+
+// RegisterResolverFunc registers a ResolverFunc and named it, it's called in
+// three forms of references:
+//
+//	${name:arg}        namespace form, the only argument is the whole arg
+//	${name(arg1,arg2)} call form, the arguments are separated by comma
+//	${name}            bare form, only when the property doesn't exist and
+//	                   the function succeeds without arguments, such as uuid
+func RegisterResolverFunc(name string, fn ResolverFunc) {
+	resolverFuncs[name] = fn
+}
+
+func init() {
+	RegisterResolverFunc("env", resolveEnv)
+	RegisterResolverFunc("file", resolveFile)
+	RegisterResolverFunc("base64", resolveBase64)
+	RegisterResolverFunc("upper", func(args ...string) (string, error) {
+		if len(args) == 0 {
+			return "", errors.New("upper needs arguments")
+		}
+		return strings.ToUpper(strings.Join(args, ",")), nil
+	})
+	RegisterResolverFunc("lower", func(args ...string) (string, error) {
+		if len(args) == 0 {
+			return "", errors.New("lower needs arguments")
+		}
+		return strings.ToLower(strings.Join(args, ",")), nil
+	})
+	RegisterResolverFunc("random.int", randomInt)
+	RegisterResolverFunc("uuid", randomUUID)
+	RegisterResolverFunc(strings.TrimSuffix(SecretPrefix, ":"), resolveSecret)
+}
+
+// resolverCall is a parsed call of a ResolverFunc.
+type resolverCall struct {
+	name string
+	fn   ResolverFunc
+	args []string // unresolved arguments
+	bare bool
+}
+
+// parseResolverCall returns the call when the key of a reference calls a
+// registered ResolverFunc.
+func parseResolverCall(key string) (resolverCall, bool) {
+	if i := strings.IndexByte(key, '('); i > 0 && strings.HasSuffix(key, ")") {
+		if fn, ok := resolverFuncs[key[:i]]; ok {
+			args := splitArgs(key[i+1 : len(key)-1])
+			return resolverCall{name: key[:i], fn: fn, args: args}, true
+		}
+	}
+	if i := strings.IndexByte(key, ':'); i > 0 {
+		if fn, ok := resolverFuncs[key[:i]]; ok {
+			return resolverCall{name: key[:i], fn: fn, args: []string{key[i+1:]}}, true
+		}
+	}
+	if fn, ok := resolverFuncs[key]; ok {
+		return resolverCall{name: key, fn: fn, bare: true}, true
+	}
+	return resolverCall{}, false
+}
+
+// splitArgs splits the arguments by the commas out of the braces.
+func splitArgs(s string) []string {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	var args []string
+	for {
+		i := indexTopLevel(s, ",")
+		if i < 0 {
+			return append(args, strings.TrimSpace(s))
+		}
+		args = append(args, strings.TrimSpace(s[:i]))
+		s = s[i+1:]
+	}
+}
+
+// callResolverFunc resolves the arguments and calls the function, it returns
+// the default value of the param when the function returns ErrNotExist.
+func callResolverFunc(p *Properties, c resolverCall, param BindParam, chain []string) (string, error) {
+	args := make([]string, len(c.args))
+	for i, arg := range c.args {
+		s, err := resolveStringRef(p, arg, chain)
+		if err != nil {
+			return "", err
+		}
+		args[i] = s
+	}
+	s, err := c.fn(args...)
+	if err != nil {
+		if errors.Is(err, ErrNotExist) && param.Tag.HasDef {
+			return resolveStringRef(p, param.Tag.Def, chain)
+		}
+		return "", fmt.Errorf("%s: resolve %q error, %w", util.FileLine(), param.Tag.Key, err)
+	}
+	if c.name == strings.TrimSuffix(SecretPrefix, ":") {
+		p.secrets.add(s)
+	}
+	return s, nil
+}
+
+func resolveEnv(args ...string) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("env needs one argument")
+	}
+	s, ok := os.LookupEnv(args[0])
+	if !ok {
+		return "", fmt.Errorf("environment variable %q %w", args[0], ErrNotExist)
+	}
+	return s, nil
+}
+
+// resolveFile returns the content of the file without trailing newlines.
+func resolveFile(args ...string) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("file needs one argument")
+	}
+	b, err := os.ReadFile(args[0])
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("file %q %w", args[0], ErrNotExist)
+		}
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// resolveBase64 decodes the base64 argument.
+func resolveBase64(args ...string) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("base64 needs one argument")
+	}
+	b, err := base64.StdEncoding.DecodeString(args[0])
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// randomInt returns a random int in [min, max), the default min is 0, and
+// the default max is math.MaxInt64.
+func randomInt(args ...string) (string, error) {
+	if len(args) > 2 {
+		return "", errors.New("random.int needs at most two arguments")
+	}
+	bounds := []int64{0, 1<<63 - 1}
+	for i, arg := range args {
+		n, err := strconv.ParseInt(arg, 0, 64)
+		if err != nil {
+			return "", err
+		}
+		bounds[len(bounds)-len(args)+i] = n
+	}
+	lo, hi := bounds[0], bounds[1]
+	if lo >= hi {
+		return "", fmt.Errorf("random.int range [%d, %d) is empty", lo, hi)
+	}
+	n, err := rand.Int(rand.Reader, new(big.Int).Sub(big.NewInt(hi), big.NewInt(lo)))
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(n.Int64()+lo, 10), nil
+}
+
+// randomUUID returns a random version 4 UUID.
+func randomUUID(args ...string) (string, error) {
+	if len(args) > 0 {
+		return "", errors.New("uuid needs no argument")
+	}
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
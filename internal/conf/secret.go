@@ -0,0 +1,195 @@
+// Copyright 2024 github.com/lvan100
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lvan100/go-conf/internal/util"
+)
+
+// SecretPrefix is the prefix of the secret references, such as
+// ${secret:db/password}.
+const SecretPrefix = "secret:"
+
+// Redacted replaces the secret values in the redacted strings.
+const Redacted = "******"
+
+// Decryptor decrypts the values in the form of ENC(ciphertext), it's passed
+// the ciphertext without ENC().
+type Decryptor interface {
+	Decrypt(ciphertext string) (string, error)
+}
+
+// SecretResolver resolves the references in the form of ${secret:name}.
+type SecretResolver interface {
+	ResolveSecret(name string) (string, error)
+}
+
+var (
+	decryptor      Decryptor
+	secretResolver SecretResolver
+)
+
+// SetDecryptor sets the Decryptor of the ENC(...) values.
+func SetDecryptor(d Decryptor) {
+	decryptor = d
+}
+
+// SetSecretResolver sets the SecretResolver of the ${secret:...} references.
+func SetSecretResolver(r SecretResolver) {
+	secretResolver = r
+}
+
+// isEncrypted returns the ciphertext when the value is in the form of ENC().
+func isEncrypted(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "ENC(") && strings.HasSuffix(s, ")") {
+		return s[4 : len(s)-1], true
+	}
+	return "", false
+}
+
+// IsSecretValue returns whether the raw value is encrypted or references a
+// secret, the values of such keys are redacted by key.
+func IsSecretValue(s string) bool {
+	if _, ok := isEncrypted(s); ok {
+		return true
+	}
+	return strings.Contains(s, "${"+SecretPrefix)
+}
+
+// decrypt decrypts the ciphertext, the plaintext is remembered by p for
+// redaction.
+func decrypt(p *Properties, ciphertext string) (string, error) {
+	if decryptor == nil {
+		err := errors.New("no decryptor")
+		return "", fmt.Errorf("%s: decrypt ENC() value error, %w", util.FileLine(), err)
+	}
+	s, err := decryptor.Decrypt(ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("%s: decrypt ENC() value error, %w", util.FileLine(), err)
+	}
+	p.secrets.add(s)
+	return s, nil
+}
+
+// resolveSecret resolves the secret name, the caller remembers the resolved
+// value for redaction.
+func resolveSecret(args ...string) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("secret needs one argument")
+	}
+	if secretResolver == nil {
+		return "", errors.New("no secret resolver")
+	}
+	return secretResolver.ResolveSecret(args[0])
+}
+
+// minRedactLen is the min length of the secrets redacted wherever they are,
+// the shorter ones are redacted only when quoted, such as "1" in the errors
+// of strconv, so that they don't corrupt the unrelated text.
+const minRedactLen = 6
+
+// secretSet is the secrets decrypted or resolved by a *Properties, it's
+// shared by the *Properties returned by WithLayer and WithSource.
+type secretSet struct {
+	mutex  sync.RWMutex
+	values map[string]struct{}
+	sorted []string // longest first
+}
+
+func newSecretSet() *secretSet {
+	return &secretSet{values: make(map[string]struct{})}
+}
+
+func (s *secretSet) add(secret string) {
+	if secret == "" {
+		return
+	}
+	s.mutex.RLock()
+	_, ok := s.values[secret]
+	s.mutex.RUnlock()
+	if ok {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.values[secret] = struct{}{}
+	s.sorted = append(s.sorted, secret)
+	sort.SliceStable(s.sorted, func(i, j int) bool {
+		return len(s.sorted[i]) > len(s.sorted[j])
+	})
+}
+
+func (s *secretSet) copy() *secretSet {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return &secretSet{
+		values: maps.Clone(s.values),
+		sorted: slices.Clone(s.sorted),
+	}
+}
+
+func (s *secretSet) redact(str string) string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, secret := range s.sorted {
+		str = strings.ReplaceAll(str, strconv.Quote(secret), strconv.Quote(Redacted))
+		if len(secret) >= minRedactLen {
+			str = strings.ReplaceAll(str, secret, Redacted)
+		}
+	}
+	return str
+}
+
+// Redact replaces the secrets that p has decrypted or resolved in the string,
+// the secrets shorter than 6 bytes are replaced only when quoted.
+func (p *Properties) Redact(s string) string {
+	return p.secrets.redact(s)
+}
+
+// RedactError returns an error whose message has the secrets redacted like
+// Redact, it still supports errors.Is and errors.As on the original error.
+func (p *Properties) RedactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	if s := p.Redact(msg); s != msg {
+		return &redactedError{msg: s, err: err}
+	}
+	return err
+}
+
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 github.com/lvan100
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"fmt"
+	"sort"
+)
+
+type ChangeType int
+
+const (
+	ChangeAdded    ChangeType = iota // ChangeAdded key exists only in the new one
+	ChangeRemoved                    // ChangeRemoved key exists only in the old one
+	ChangeModified                   // ChangeModified key exists in both but values differ
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case ChangeAdded:
+		return "added"
+	case ChangeRemoved:
+		return "removed"
+	case ChangeModified:
+		return "modified"
+	default:
+		return fmt.Sprintf("ChangeType(%d)", int(t))
+	}
+}
+
+// Change is the change of a key between two storages.
+type Change struct {
+	Key  string
+	Type ChangeType
+	Old  string // empty when added
+	New  string // empty when removed
+}
+
+func (c Change) String() string {
+	switch c.Type {
+	case ChangeAdded:
+		return fmt.Sprintf("%s %s=%q", c.Type, c.Key, c.New)
+	case ChangeRemoved:
+		return fmt.Sprintf("%s %s=%q", c.Type, c.Key, c.Old)
+	default:
+		return fmt.Sprintf("%s %s=%q->%q", c.Type, c.Key, c.Old, c.New)
+	}
+}
+
+// Diff returns the changes from old to new sorted by key, a nil storage
+// is treated as an empty one.
+func Diff(old, new *Storage) []Change {
+	var o, n map[string]string
+	if old != nil {
+		o = old.data
+	}
+	if new != nil {
+		n = new.data
+	}
+	var changes []Change
+	for _, key := range OrderedMapKeys(o) {
+		oldVal := o[key]
+		newVal, ok := n[key]
+		if !ok {
+			changes = append(changes, Change{Key: key, Type: ChangeRemoved, Old: oldVal})
+		} else if newVal != oldVal {
+			changes = append(changes, Change{Key: key, Type: ChangeModified, Old: oldVal, New: newVal})
+		}
+	}
+	for key, newVal := range n {
+		if _, ok := o[key]; !ok {
+			changes = append(changes, Change{Key: key, Type: ChangeAdded, New: newVal})
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
@@ -17,7 +17,12 @@ package store
 import (
 	"cmp"
 	"fmt"
+	"maps"
+	"slices"
 	"sort"
+	"strconv"
+	"strings"
+	"unicode"
 )
 
 type nodeType int
@@ -56,6 +61,34 @@ func (t *treeNode) Copy() *treeNode {
 type Storage struct {
 	tree *treeNode
 	data map[string]string
+
+	canonical  bool
+	spellings  map[string]string // canonical key -> last spelling set
+	collisions []Collision
+}
+
+// Collision is recorded when two different spellings of a key are merged
+// into the same canonical key, the value of New overrides the one of Old.
+type Collision struct {
+	Key string // canonical key
+	Old string // spelling set before
+	New string // spelling set later
+}
+
+func (c Collision) String() string {
+	return fmt.Sprintf("%s: %s overridden by %s", c.Key, c.Old, c.New)
+}
+
+// CanonicalKey returns the canonical form of the key, which is lowercase and
+// has no '-' or '_', so that `Server.Max-Conns` and `server.max_conns` are
+// the same key `server.maxconns`.
+func CanonicalKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, key)
 }
 
 func NewStorage() *Storage {
@@ -70,10 +103,58 @@ func NewStorage() *Storage {
 
 // Copy returns a new copy of the storage.
 func (s *Storage) Copy() *Storage {
-	return &Storage{
-		tree: s.tree.Copy(),
-		data: s.Data(),
+	r := &Storage{
+		tree:       s.tree.Copy(),
+		data:       s.Data(),
+		canonical:  s.canonical,
+		collisions: slices.Clone(s.collisions),
 	}
+	if s.spellings != nil {
+		r.spellings = maps.Clone(s.spellings)
+	}
+	return r
+}
+
+// Canonicalize makes the storage use the canonical keys on both Set and
+// lookups, the existing keys are converted in order, and the collisions
+// between them are recorded.
+func (s *Storage) Canonicalize() error {
+	if s.canonical {
+		return nil
+	}
+	data := s.data
+	s.tree = &treeNode{
+		node: nodeTypeMap,
+		data: make(map[string]*treeNode),
+	}
+	s.data = make(map[string]string)
+	s.canonical = true
+	s.spellings = make(map[string]string)
+	for _, key := range OrderedMapKeys(data) {
+		if err := s.Set(key, data[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Canonical returns whether the storage uses the canonical keys.
+func (s *Storage) Canonical() bool {
+	return s.canonical
+}
+
+// Key returns the key stored in the storage, which is the canonical key when
+// the storage uses the canonical keys.
+func (s *Storage) Key(key string) string {
+	if s.canonical {
+		return CanonicalKey(key)
+	}
+	return key
+}
+
+// Collisions returns the collisions of the keys in order.
+func (s *Storage) Collisions() []Collision {
+	return slices.Clone(s.collisions)
 }
 
 // Data returns the data of the storage.
@@ -92,7 +173,7 @@ func (s *Storage) Keys() []string {
 
 // SubKeys returns the sorted sub keys of the key.
 func (s *Storage) SubKeys(key string) ([]string, error) {
-	path, err := SplitPath(key)
+	path, err := SplitPath(s.Key(key))
 	if err != nil {
 		return nil, err
 	}
@@ -119,9 +200,66 @@ func (s *Storage) SubKeys(key string) ([]string, error) {
 	return keys, nil
 }
 
+// Tree returns the nested structure of the storage, map nodes become
+// map[string]interface{}, array nodes become []interface{}, value nodes
+// become string, and nil nodes (empty value, map or array) become nil.
+func (s *Storage) Tree() map[string]interface{} {
+	return s.nested(s.tree, nil).(map[string]interface{})
+}
+
+func (s *Storage) nested(tree *treeNode, path []Path) interface{} {
+	switch tree.node {
+	case nodeTypeMap:
+		m := tree.data.(map[string]*treeNode)
+		r := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			r[k] = s.nested(v, append(path, Path{PathTypeKey, k}))
+		}
+		return r
+	case nodeTypeArray:
+		m := tree.data.(map[string]*treeNode)
+		n := 0
+		for k := range m {
+			i, _ := strconv.Atoi(k)
+			n = max(n, i+1)
+		}
+		r := make([]interface{}, n)
+		for k, v := range m {
+			i, _ := strconv.Atoi(k)
+			r[i] = s.nested(v, append(path, Path{PathTypeIndex, k}))
+		}
+		return r
+	case nodeTypeValue:
+		return s.data[JoinPath(path)]
+	default:
+		return nil
+	}
+}
+
+// SubTree returns the nested structure of the key like Tree, and nil when
+// the key doesn't exist.
+func (s *Storage) SubTree(key string) interface{} {
+	path, err := SplitPath(s.Key(key))
+	if err != nil {
+		return nil
+	}
+	tree := s.tree
+	for _, node := range path {
+		if tree.node != nodeTypeMap && tree.node != nodeTypeArray {
+			return nil
+		}
+		v, ok := tree.data.(map[string]*treeNode)[node.Elem]
+		if !ok {
+			return nil
+		}
+		tree = v
+	}
+	return s.nested(tree, path)
+}
+
 // Has returns whether the key exists.
 func (s *Storage) Has(key string) bool {
-	path, err := SplitPath(key)
+	path, err := SplitPath(s.Key(key))
 	if err != nil {
 		return false
 	}
@@ -153,12 +291,15 @@ func (s *Storage) Has(key string) bool {
 
 // Get returns the value of the key, and false if the key does not exist.
 func (s *Storage) Get(key string) (string, bool) {
-	val, ok := s.data[key]
+	val, ok := s.data[s.Key(key)]
 	return val, ok
 }
 
-// Set stores the value of the key.
+// Set stores the value of the key, when the storage uses the canonical keys
+// and the key has been set in another spelling, a collision is recorded.
 func (s *Storage) Set(key, val string) error {
+	spelling := key
+	key = s.Key(key)
 	tree, err := s.merge(key, val)
 	if err != nil {
 		return err
@@ -166,6 +307,12 @@ func (s *Storage) Set(key, val string) error {
 	switch tree.node {
 	case nodeTypeNil, nodeTypeValue:
 		s.data[key] = val
+		if s.canonical {
+			if old, ok := s.spellings[key]; ok && old != spelling {
+				s.collisions = append(s.collisions, Collision{Key: key, Old: old, New: spelling})
+			}
+			s.spellings[key] = spelling
+		}
 	default:
 		return fmt.Errorf("invalid node type %d, !!! should never happen", tree.node)
 	}
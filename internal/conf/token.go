@@ -0,0 +1,109 @@
+// Copyright 2024 github.com/lvan100
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lvan100/go-conf/internal/util"
+)
+
+// The syntax of references shared by ParseTag and resolveString:
+//
+//	${key}, ${key:=default}  a reference, the braces in it must be balanced,
+//	                         so the default can contain references and json
+//	$${                      a literal "${" out of references
+//
+// The key and the default are separated by the first ":=" out of the nested
+// braces, and a tag may have a splitter after the reference, ${key}>>name.
+// There is no escape for a brace in a reference, so a default with an
+// unbalanced brace must be set to another key and referenced, such as
+// ${key:=${brace}}.
+
+// scanRef returns the literal text before the first reference of s with the
+// escaped "$${" unescaped, the reference, and the rest after it. The ref is
+// empty when there is no reference, and ok is false when the reference isn't
+// closed.
+func scanRef(s string) (text, ref, rest string, ok bool) {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if strings.HasPrefix(s[i:], "$${") {
+			sb.WriteString("${")
+			i += 2
+			continue
+		}
+		if strings.HasPrefix(s[i:], "${") {
+			end := refEnd(s, i)
+			if end < 0 {
+				return "", "", "", false
+			}
+			return sb.String(), s[i : end+1], s[end+1:], true
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String(), "", "", true
+}
+
+// References returns the top level references in s, such as ${a} and
+// ${b:=${c}} in "${a}-${b:=${c}}", the escaped "$${" is skipped. It fails
+// with ErrInvalidSyntax when a reference isn't closed.
+func References(s string) ([]string, error) {
+	var refs []string
+	for {
+		_, ref, rest, ok := scanRef(s)
+		if !ok {
+			return nil, fmt.Errorf("%s: scan string %q error, %w", util.FileLine(), s, ErrInvalidSyntax)
+		}
+		if ref == "" {
+			return refs, nil
+		}
+		refs = append(refs, ref)
+		s = rest
+	}
+}
+
+// refEnd returns the index of the brace closing the reference at start, or
+// -1 when the reference isn't closed.
+func refEnd(s string, start int) int {
+	depth := 0
+	for i := start + 1; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// indexTopLevel returns the index of the first sep out of the braces.
+func indexTopLevel(s string, sep string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '{':
+			depth++
+		case s[i] == '}' && depth > 0:
+			depth--
+		case depth == 0 && strings.HasPrefix(s[i:], sep):
+			return i
+		}
+	}
+	return -1
+}
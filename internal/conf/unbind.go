@@ -0,0 +1,307 @@
+// Copyright 2024 github.com/lvan100
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/lvan100/go-conf/internal/conf/store"
+	"github.com/lvan100/go-conf/internal/flat"
+	"github.com/lvan100/go-conf/internal/util"
+)
+
+var (
+	formatters = map[reflect.Type]Formatter{}
+)
+
+// Formatter formats user-defined value into string, it's the reverse of
+// Converter. It should be function type, and its prototype is
+// func(type)(string,error).
+type Formatter interface{}
+
+// RegisterFormatter registers its formatter for non-primitive type such as
+// time.Time, time.Duration, or other user-defined value type.
+func RegisterFormatter(fn Formatter) {
+	t := reflect.TypeOf(fn)
+	if !IsFormatter(t) {
+		panic(errors.New("formatter is func(type)(string,error)"))
+	}
+	formatters[t.In(0)] = fn
+}
+
+// FromStruct creates *Properties from a value, see Properties.Unbind.
+func FromStruct(i interface{}, args ...BindArg) (*Properties, error) {
+	p := New()
+	if err := p.Unbind(i, args...); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Unbind is the reverse of Bind, it writes a value into properties. It walks
+// the value in the same way as Bind does, and the keys are decided by the
+// same 'value' tags and naming rules. The actual values are written, use the
+// Defaults option to write the default values of the zero-valued fields.
+func (p *Properties) Unbind(i interface{}, args ...BindArg) error {
+
+	v, ok := i.(reflect.Value)
+	if !ok {
+		v = reflect.ValueOf(i)
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return errors.New("should not be a nil ptr")
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return errors.New("should not be nil")
+	}
+
+	t := v.Type()
+	typeName := t.Name()
+	if typeName == "" { // primitive type has no name
+		typeName = t.String()
+	}
+
+	param, err := getBindParam(args)
+	if err != nil {
+		return err
+	}
+	param.Path = typeName
+	return UnbindValue(p, v, t, param)
+}
+
+// dynamicValue is implemented by Dynamic to get its value without knowing T.
+type dynamicValue interface {
+	dynamicValue() interface{}
+}
+
+func (d *Dynamic[T]) dynamicValue() interface{} {
+	return d.Value()
+}
+
+// UnbindValue writes a value into properties.
+func UnbindValue(p *Properties, v reflect.Value, t reflect.Type, param BindParam) error {
+
+	if v.CanAddr() && v.Addr().CanInterface() {
+		if d, ok := v.Addr().Interface().(dynamicValue); ok {
+			v = reflect.ValueOf(d.dynamicValue())
+			return UnbindValue(p, v, v.Type(), param)
+		}
+	}
+
+	if !IsValueType(t) {
+		err := errors.New("target should be value type")
+		return fmt.Errorf("%s: unbind %s error, %w", util.FileLine(), param.Path, err)
+	}
+
+	if param.opts.defaults && v.IsZero() && param.Tag.HasDef {
+		if param.Tag.Def != "" || v.Kind() != reflect.Struct {
+			return unbindString(p, param, param.Tag.Def)
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return UnbindValue(p, v.Elem(), t.Elem(), param)
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		if param.Key == "" {
+			err := errors.New("key is empty")
+			return fmt.Errorf("%s: unbind %s error, %w", util.FileLine(), param.Path, err)
+		}
+		m := make(map[string]string)
+		flat.FlattenValue(param.Key, v.Interface(), m)
+		if err := p.merge(m); err != nil {
+			return fmt.Errorf("%s: unbind %s error, %w", util.FileLine(), param.Path, err)
+		}
+		return nil
+	default: // for linter
+	}
+
+	if fn := formatters[t]; fn != nil {
+		out := reflect.ValueOf(fn).Call([]reflect.Value{v})
+		if !out[1].IsNil() {
+			err := out[1].Interface().(error)
+			return fmt.Errorf("%s: unbind %s error, %w", util.FileLine(), param.Path, err)
+		}
+		return unbindString(p, param, out[0].String())
+	}
+
+	if m, ok := textMarshaler(v); ok {
+		b, err := m.MarshalText()
+		if err != nil {
+			return fmt.Errorf("%s: unbind %s error, %w", util.FileLine(), param.Path, err)
+		}
+		return unbindString(p, param, string(b))
+	}
+
+	switch v.Kind() {
+	case reflect.Map:
+		return unbindMap(p, v, t, param)
+	case reflect.Slice, reflect.Array:
+		return unbindSlice(p, v, t, param)
+	case reflect.Struct:
+		if converters[t] != nil {
+			err := fmt.Errorf("can't find formatter for %s", t.String())
+			return fmt.Errorf("%s: unbind %s error, %w", util.FileLine(), param.Path, err)
+		}
+		return unbindStruct(p, v, t, param)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return unbindString(p, param, strconv.FormatInt(v.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return unbindString(p, param, strconv.FormatUint(v.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		return unbindString(p, param, strconv.FormatFloat(v.Float(), 'g', -1, t.Bits()))
+	case reflect.Bool:
+		return unbindString(p, param, strconv.FormatBool(v.Bool()))
+	case reflect.String:
+		return unbindString(p, param, v.String())
+	default: // for linter
+	}
+
+	err := fmt.Errorf("unsupported unbind type %q", t.String())
+	return fmt.Errorf("%s: unbind %s error, %w", util.FileLine(), param.Path, err)
+}
+
+// textMarshaler returns the encoding.TextMarshaler implemented by the value
+// or its pointer, so that the types bound by UnmarshalText can be written back.
+func textMarshaler(v reflect.Value) (encoding.TextMarshaler, bool) {
+	if !v.CanInterface() {
+		return nil, false
+	}
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		return m, true
+	}
+	if v.CanAddr() {
+		m, ok := v.Addr().Interface().(encoding.TextMarshaler)
+		return m, ok
+	}
+	return nil, false
+}
+
+// unbindString writes a string value into properties.
+func unbindString(p *Properties, param BindParam, val string) error {
+	if param.Key == "" {
+		err := errors.New("key is empty")
+		return fmt.Errorf("%s: unbind %s error, %w", util.FileLine(), param.Path, err)
+	}
+	if err := p.store(param.Key, val); err != nil {
+		return fmt.Errorf("%s: unbind %s error, %w", util.FileLine(), param.Path, err)
+	}
+	return nil
+}
+
+// unbindSlice writes a slice or an array value into properties.
+func unbindSlice(p *Properties, v reflect.Value, t reflect.Type, param BindParam) error {
+	if v.Len() == 0 {
+		return unbindString(p, param, "")
+	}
+	et := t.Elem()
+	for i := 0; i < v.Len(); i++ {
+		subParam := BindParam{
+			Key:  fmt.Sprintf("%s[%d]", param.Key, i),
+			Path: fmt.Sprintf("%s[%d]", param.Path, i),
+			opts: param.opts,
+		}
+		if err := UnbindValue(p, v.Index(i), et, subParam); err != nil {
+			return fmt.Errorf("%s: unbind %s error, %w", util.FileLine(), param.Path, err)
+		}
+	}
+	return nil
+}
+
+// unbindMap writes a map value into properties.
+func unbindMap(p *Properties, v reflect.Value, t reflect.Type, param BindParam) error {
+	if v.Len() == 0 {
+		return unbindString(p, param, "")
+	}
+	keys := make(map[string]reflect.Value, v.Len())
+	for _, k := range v.MapKeys() {
+		keys[fmt.Sprint(k.Interface())] = k
+	}
+	et := t.Elem()
+	for _, key := range store.OrderedMapKeys(keys) {
+		subKey := key
+		if param.Key != "" {
+			subKey = param.Key + "." + key
+		}
+		subParam := BindParam{
+			Key:  subKey,
+			Path: param.Path,
+			opts: param.opts,
+		}
+		if err := UnbindValue(p, v.MapIndex(keys[key]), et, subParam); err != nil {
+			return fmt.Errorf("%s: unbind %s error, %w", util.FileLine(), param.Path, err)
+		}
+	}
+	return nil
+}
+
+// unbindStruct writes a struct value into properties, it walks the fields
+// by the bind plan of the struct, so the keys are the same as Bind uses.
+func unbindStruct(p *Properties, v reflect.Value, t reflect.Type, param BindParam) error {
+
+	plan := getBindPlan(t)
+	opts := plan.structOptions(param.opts)
+	opts.naming.relaxed = false
+
+	for i := range plan.fields {
+		f := &plan.fields[i]
+		if f.kind == fieldSkipped {
+			continue
+		}
+
+		key, err := f.namedFieldKey(nil, param.Key, opts.naming)
+		if err != nil {
+			return fmt.Errorf("%s: unbind %s error, %w", util.FileLine(), param.Path, err)
+		}
+
+		subParam := BindParam{
+			Key:  key,
+			Path: param.Path + f.path,
+			opts: opts,
+		}
+
+		fv := v.Field(f.index)
+		switch f.kind {
+		case fieldTagged:
+			if f.tagErr != nil {
+				return fmt.Errorf("%s: unbind %s error, %w", util.FileLine(), param.Path, f.tagErr)
+			}
+			subParam.Tag = f.tag
+			subParam.Validate = f.validate
+			err = UnbindValue(p, fv, f.typ, subParam)
+		case fieldEmbedded:
+			err = unbindStruct(p, fv, f.typ, subParam)
+		default:
+			err = UnbindValue(p, fv, f.typ, subParam)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: unbind %s error, %w", util.FileLine(), param.Path, err)
+		}
+	}
+	return nil
+}
@@ -40,11 +40,27 @@ func IsConverter(t reflect.Type) bool {
 		(IsValueType(t.Out(0)) || IsFuncType(t.Out(0))) && IsErrorType(t.Out(1))
 }
 
+// IsFormatter returns whether `t` is a formatter type.
+func IsFormatter(t reflect.Type) bool {
+	return IsFuncType(t) &&
+		t.NumIn() == 1 &&
+		IsValueType(t.In(0)) &&
+		t.NumOut() == 2 &&
+		t.Out(0).Kind() == reflect.String && IsErrorType(t.Out(1))
+}
+
 // IsValueType returns whether the input type is the primitive value type and their
 // composite type including array, slice, map and struct, such as []int, [3]string,
-// []string, map[int]int, map[string]string, etc.
+// []string, map[int]int, map[string]string, etc. The pointer to them, such as *int,
+// []*int, map[string]*Struct, and the empty interface are also value types.
 func IsValueType(t reflect.Type) bool {
 	fn := func(t reflect.Type) bool {
+		if IsEmptyInterface(t) {
+			return true
+		}
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
 		return IsPrimitiveValueType(t) || t.Kind() == reflect.Struct
 	}
 	switch t.Kind() {
@@ -55,6 +71,11 @@ func IsValueType(t reflect.Type) bool {
 	}
 }
 
+// IsEmptyInterface returns whether `t` is the interface{} type.
+func IsEmptyInterface(t reflect.Type) bool {
+	return t.Kind() == reflect.Interface && t.NumMethod() == 0
+}
+
 // IsPrimitiveValueType returns whether `t` is the primitive value type which only is
 // int, unit, float, bool, string and complex.
 func IsPrimitiveValueType(t reflect.Type) bool {
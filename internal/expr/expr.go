@@ -16,11 +16,15 @@ package expr
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
 )
 
-type Validator struct{}
+type Validator struct {
+	programs sync.Map // compiled programs by tag, map[string]*vm.Program
+}
 
 // Name returns the name of the validator.
 func (d *Validator) Name() string {
@@ -29,7 +33,11 @@ func (d *Validator) Name() string {
 
 // Field validates the field with the given tag and value.
 func (d *Validator) Field(tag string, i interface{}) error {
-	r, err := expr.Eval(tag, map[string]interface{}{"$": i})
+	program, err := d.compile(tag)
+	if err != nil {
+		return fmt.Errorf("eval %q returns error, %w", tag, err)
+	}
+	r, err := expr.Run(program, map[string]interface{}{"$": i})
 	if err != nil {
 		return fmt.Errorf("eval %q returns error, %w", tag, err)
 	}
@@ -42,3 +50,16 @@ func (d *Validator) Field(tag string, i interface{}) error {
 	}
 	return nil
 }
+
+// compile returns the compiled program of the tag, it's compiled only once.
+func (d *Validator) compile(tag string) (*vm.Program, error) {
+	if v, ok := d.programs.Load(tag); ok {
+		return v.(*vm.Program), nil
+	}
+	program, err := expr.Compile(tag)
+	if err != nil {
+		return nil, err
+	}
+	d.programs.Store(tag, program)
+	return program, nil
+}
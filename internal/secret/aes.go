@@ -0,0 +1,106 @@
+// Copyright 2024 github.com/lvan100
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// AESGCM encrypts and decrypts the values by AES-GCM, the ciphertext is the
+// base64 of the nonce followed by the sealed value.
+type AESGCM struct {
+	aead cipher.AEAD
+}
+
+// NewAESGCM returns an AESGCM of the 16, 24 or 32 bytes key.
+func NewAESGCM(key []byte) (*AESGCM, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &AESGCM{aead: aead}, nil
+}
+
+// FromEnv returns an AESGCM of the base64 key in the environment variable.
+func FromEnv(name string) (*AESGCM, error) {
+	s, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, fmt.Errorf("environment variable %s not found", name)
+	}
+	c, err := fromBase64(s)
+	if err != nil {
+		return nil, fmt.Errorf("key in environment variable %s error, %w", name, err)
+	}
+	return c, nil
+}
+
+// FromFile returns an AESGCM of the base64 key in the file.
+func FromFile(file string) (*AESGCM, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	c, err := fromBase64(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("key in file %s error, %w", file, err)
+	}
+	return c, nil
+}
+
+func fromBase64(s string) (*AESGCM, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	return NewAESGCM(key)
+}
+
+// Encrypt returns the value in the form of ENC(ciphertext).
+func (c *AESGCM) Encrypt(plaintext string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	b := c.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return "ENC(" + base64.StdEncoding.EncodeToString(b) + ")", nil
+}
+
+// Decrypt decrypts the ciphertext in ENC(ciphertext).
+func (c *AESGCM) Decrypt(ciphertext string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(ciphertext))
+	if err != nil {
+		return "", err
+	}
+	n := c.aead.NonceSize()
+	if len(b) < n {
+		return "", errors.New("ciphertext too short")
+	}
+	plaintext, err := c.aead.Open(nil, b[:n], b[n:], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
@@ -27,3 +27,12 @@ func Read(b []byte) (map[string]interface{}, error) {
 	}
 	return ret, nil
 }
+
+// Write serializes map into []byte in the json format.
+func Write(m map[string]interface{}) ([]byte, error) {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
@@ -14,7 +14,14 @@
 
 package prop
 
-import "github.com/magiconair/properties"
+import (
+	"bytes"
+
+	"github.com/magiconair/properties"
+
+	"github.com/lvan100/go-conf/internal/conf/store"
+	"github.com/lvan100/go-conf/internal/flat"
+)
 
 // Read parses []byte in the properties format into map.
 func Read(b []byte) (map[string]interface{}, error) {
@@ -29,3 +36,21 @@ func Read(b []byte) (map[string]interface{}, error) {
 	}
 	return ret, nil
 }
+
+// Write serializes map into []byte in the properties format, the keys are
+// flattened and sorted.
+func Write(m map[string]interface{}) ([]byte, error) {
+	s := flat.FlattenMap(m)
+	p := properties.NewProperties()
+	p.DisableExpansion = true
+	for _, k := range store.OrderedMapKeys(s) {
+		if _, _, err := p.Set(k, s[k]); err != nil {
+			return nil, err
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := p.Write(&buf, properties.UTF8); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -15,6 +15,8 @@
 package toml
 
 import (
+	"fmt"
+
 	"github.com/pelletier/go-toml"
 )
 
@@ -26,3 +28,66 @@ func Read(b []byte) (map[string]interface{}, error) {
 	}
 	return tree.ToMap(), nil
 }
+
+// ReadLines returns the line numbers of the keys in []byte in the toml
+// format, the keys are flattened as `a.b` and `a[0].b`.
+func ReadLines(b []byte) (map[string]int, error) {
+	tree, err := toml.LoadBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	lines := make(map[string]int)
+	readLines(tree, "", lines)
+	return lines, nil
+}
+
+func readLines(tree *toml.Tree, prefix string, lines map[string]int) {
+	for _, k := range tree.Keys() {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		lines[key] = tree.GetPositionPath([]string{k}).Line
+		switch v := tree.GetPath([]string{k}).(type) {
+		case *toml.Tree:
+			readLines(v, key, lines)
+		case []*toml.Tree:
+			for i, t := range v {
+				sub := fmt.Sprintf("%s[%d]", key, i)
+				lines[sub] = t.Position().Line
+				readLines(t, sub, lines)
+			}
+		}
+	}
+}
+
+// Write serializes map into []byte in the toml format, nil values are
+// written as empty strings because toml has no null value.
+func Write(m map[string]interface{}) ([]byte, error) {
+	tree, err := toml.TreeFromMap(replaceNil(m).(map[string]interface{}))
+	if err != nil {
+		return nil, err
+	}
+	return tree.Marshal()
+}
+
+func replaceNil(v interface{}) interface{} {
+	switch e := v.(type) {
+	case nil:
+		return ""
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(e))
+		for k, x := range e {
+			m[k] = replaceNil(x)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(e))
+		for i, x := range e {
+			s[i] = replaceNil(x)
+		}
+		return s
+	default:
+		return v
+	}
+}
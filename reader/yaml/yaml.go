@@ -15,6 +15,10 @@
 package yaml
 
 import (
+	"bytes"
+	"errors"
+	"io"
+
 	"gopkg.in/yaml.v2"
 )
 
@@ -27,3 +31,25 @@ func Read(b []byte) (map[string]interface{}, error) {
 	}
 	return m, nil
 }
+
+// ReadDocuments parses []byte in the yaml format that contains multiple
+// documents separated by "---" into maps, one for each document.
+func ReadDocuments(b []byte) ([]map[string]interface{}, error) {
+	var docs []map[string]interface{}
+	d := yaml.NewDecoder(bytes.NewReader(b))
+	for {
+		m := make(map[string]interface{})
+		if err := d.Decode(&m); err != nil {
+			if errors.Is(err, io.EOF) {
+				return docs, nil
+			}
+			return nil, err
+		}
+		docs = append(docs, m)
+	}
+}
+
+// Write serializes map into []byte in the yaml format.
+func Write(m map[string]interface{}) ([]byte, error) {
+	return yaml.Marshal(m)
+}